@@ -3,9 +3,10 @@ package main
 import (
 	"DistKV/src/utilities"
 	"bufio"
+	"errors"
+	"flag"
 	"fmt"
 	"math/rand"
-	"net"
 	"os"
 	"strconv"
 	"strings"
@@ -23,11 +24,41 @@ client.go
 //role of the worker: can be "primary" or "replica"
 var role string
 
-//consistency guarantee of distributed KV store, can be "eventual", "sequential", or "linearizable"
+//connections to workers and the tester, every message is sent through it
+var pool *utilities.Pool
+
+//consistency guarantee of distributed KV store, can be "eventual", "causal", "sequential", "linearizable", "chain", or "multiwriter"
 var consistency string
 
-//list of strings of format "ip:port" for the various replicas in the system
-var replicas []string
+//in multiwriter mode: causal context of each key, from the last get or set of it
+//sent with the next set of the key, which then overwrites every sibling the get returned
+var contexts map[string]utilities.VectorClock
+
+//mutex to protect access to contexts
+var contextsMutex sync.RWMutex
+
+//highest write index this client has seen from each group, by the group's primary, from its own writes and the versions of values it read
+//every group numbers its writes on its own, so only the entry of the group a request goes to is sent with it
+//in causal mode it is sent with every request so replicas don't answer from before it
+//in eventual mode it is the session token: replicas that haven't applied up to it pass reads on to the primary
+var dependencies map[string]int
+
+//mutex to protect access to dependencies
+var dependencyMutex sync.RWMutex
+
+//replica groups the key space is split between, one group unless init.txt lists several primaries
+//replica lists can change at runtime when a primary sends a replicas-update
+var groups []utilities.Group
+
+//mutex to protect access to groups
+var groupsMutex sync.RWMutex
+
+//consistent hash ring picking the group that owns a key
+var ring *utilities.HashRing
+
+//key prefixes that were migrated to another cluster, mapped to that cluster's primary
+//filled in from redirect replies, checked before the hash ring. protected by groupsMutex
+var redirects map[string]string
 
 //string of the ip:port where ip is current proc's WAN address, port is the port it is listening on
 var self string
@@ -35,22 +66,47 @@ var self string
 //string of the ip:port that the tester is listening on
 var tester string
 
-//string of the ip:port that the primary is listening on (can be equal to self)
-var primary string
+//queue of incoming messages, filled by the producers and emptied by the consumer
+var messages = utilities.NewMessageQueue()
 
-//buffer containing input message strings
-var messageBuffer []string
+//map to store responses
+//a request id ("__CLIENTID__#__SEQUENCE__") is used as unique identifier for each request
+var responses map[string][]utilities.Message
 
-//mutex to protect access to messageBuffer
-var bufferMutex sync.RWMutex
+//identifies this client in request ids: its address and when it started, so a restarted client doesn't reuse ids
+var clientID string
 
-//map to store responses
-//unix timestamp is used as unique identifier for each variable setting
-var responses map[string][]string
+//sequence number of the last request this client sent
+var sequence int
+
+//mutex to protect access to sequence
+var sequenceMutex sync.RWMutex
 
 //mutex to protect access to responses
 var responseMutex sync.RWMutex
 
+//notified whenever a response is added
+var responsesChanged utilities.Signal
+
+//requests sent asynchronously (set!, get!, ... or inside a pipeline) that haven't finished, request id -> query
+var outstanding map[string]string
+
+//mutex to protect access to outstanding
+var outstandingMutex sync.RWMutex
+
+//counts outstanding requests, await, end and exit wait for it to reach zero
+var outstandingGroup sync.WaitGroup
+
+//outcome of every transaction this client coordinated, txid -> "commit" or "abort"
+//loaded from the decision log on startup so participants can still be answered after a crash
+var decisions map[string]string
+
+//transactions still collecting votes, status queries for them are ignored until there is a decision
+var activeTxns map[string]bool
+
+//mutex to protect access to decisions and activeTxns
+var txnMutex sync.RWMutex
+
 //bool to identify whether we are in test mode
 var testModeEnabled int
 
@@ -72,30 +128,47 @@ var log string
 //mutex to protect access to log
 var logMutex sync.RWMutex
 
-//arguments: port to listen on and consistency
+//arguments: port to listen on
+//-listen listens on another address than localhost:PORT (every interface, one IP, IPv6 or "unix:PATH"), the port can then be left out
+//either way the client is reached at, and names its files after, the address init.txt gives it
 func main() {
 
 	testModeEnabled = -1
 	instrFile = ""
 
-	if len(os.Args) < 2 {
-		fmt.Fprintf(os.Stderr, "Error: takes one arg (port)")
+	listen := flag.String("listen", "", "address to listen on instead of localhost:PORT (\":9002\", \"[::1]:9002\", \"unix:/tmp/client.sock\")")
+	flag.Parse()
+	if flag.NArg() < 1 && *listen == "" {
+		fmt.Fprintf(os.Stderr, "Error: takes one arg (port), or -listen ADDRESS")
 		os.Exit(1)
 	}
-	port, err := strconv.Atoi(os.Args[1])
-	if err != nil || port < 0 || port >= 65535 {
-		panic(os.Args[1] + " is not a valid port")
+	if *listen == "" {
+		port, err := strconv.Atoi(flag.Arg(0))
+		if err != nil || port < 0 || port >= 65535 {
+			panic(flag.Arg(0) + " is not a valid port")
+		}
+		*listen = "localhost:" + strconv.Itoa(port)
 	}
 
-	listener, err := net.Listen("tcp", "localhost:"+strconv.Itoa(port))
+	listener, err := utilities.Listen(*listen)
 	if err != nil {
 		panic(err)
 	}
 
 	//initializing maps
-	responses = map[string][]string{}
-
-	go producerWrapper(listener)
+	responses = map[string][]utilities.Message{}
+	redirects = map[string]string{}
+	contexts = map[string]utilities.VectorClock{}
+	dependencies = map[string]int{}
+	decisions = map[string]string{}
+	activeTxns = map[string]bool{}
+	outstanding = map[string]string{}
+
+	//every message to and from this process goes over the pool's connections, which hand incoming ones to producer
+	//until the tester says otherwise the client is reached at its listener's address
+	pool = utilities.NewPool(utilities.ListenerAddress(listener), utilities.PoolSize, producer)
+	utilities.UsePool(pool)
+	go pool.Serve(listener)
 	go consumer()
 
 	scan := bufio.NewReader(os.Stdin)
@@ -136,6 +209,9 @@ func main() {
 		scan = bufio.NewReader(f)
 	}
 
+	//set between a pipeline line and its end
+	pipelining := false
+
 	//client repl
 L1:
 	for {
@@ -157,57 +233,40 @@ L1:
 		logMutex.Unlock()
 
 		if query == "exit" {
+			//the log is only written once every outstanding request has finished
+			outstandingGroup.Wait()
 			fmt.Print("Goodbye\n")
 			break L1
 		}
 		spl := strings.Split(query, " ")
-		keyword := utilities.TrimString(spl[0])
-		identifier := fmt.Sprint(time.Now().Unix())
-
-		switch keyword {
-		case "get":
-			//eventual or sequential will get from random replica (be sure to print which one)
-			//linearizable wil get from the primary
-			var destination string
-			if consistency == "linearizable" {
-				destination = primary
-			} else {
-				var idx int
-				if len(spl) < 3 {
-					idx = rand.Intn(len(replicas))
-				} else {
-					//optional argument to specify which replica to access
-					//added for ease of testing
-					idx, err = strconv.Atoi(spl[2])
-					if err != nil || idx >= len(replicas) {
-						idx = rand.Intn(len(replicas))
-					}
-				}
-				destination = replicas[idx]
-			}
-
-			utilities.SendMessage("get "+spl[1]+" "+self+" "+identifier, destination)
-			//waiting on resp...
-			waitForSingleResponse(utilities.TrimString(identifier))
-
-		case "set":
-			//clientside logic is same across all consistencies, set to the primary and wait for response
-			utilities.SendMessage("primary-set "+spl[1]+" "+spl[2]+" "+self+" "+identifier, primary)
-			waitForSingleResponse(utilities.TrimString(identifier))
-		case "wait":
-			delta, _ := strconv.Atoi(utilities.TrimString(spl[1]))
-			for delta > 0 {
-				time.Sleep(time.Second)
-				delta--
+		switch utilities.TrimString(spl[0]) {
+		case "pipeline":
+			//every query up to the matching end is sent without waiting for the ones before it
+			pipelining = true
+			finish(query, startTime, nil)
+			continue
+		case "end", "await":
+			//end closes a pipeline, await can be used anywhere, both wait for every outstanding request
+			pipelining = false
+			outstandingGroup.Wait()
+			finish(query, startTime, nil)
+			continue
+		case "outstanding":
+			outstandingMutex.RLock()
+			for identifier, pending := range outstanding {
+				fmt.Print(identifier + ": " + pending + "\n")
 			}
-
+			outstandingMutex.RUnlock()
+			finish(query, startTime, nil)
+			continue
 		}
 
-		endTime := utilities.GetTimeInMillis()
-		logMutex.Lock()
-		log += "FINISHED @ " + strconv.Itoa(int(startTime)) + " (LATENCY: " + strconv.Itoa(int(endTime-startTime)) + " ms): " + query + "\n"
-		logMutex.Unlock()
-
+		identifier := nextRequestID()
+		if strings.HasSuffix(spl[0], "!") || pipelining && spl[0] != "wait" {
+			sendAsync(query, identifier, startTime)
+			continue
+		}
+		finish(query, startTime, execute(query, identifier))
 	}
 	//out, err := os.Open("../../output_files/" + removeColon(self) + ".log")
 	if err != nil {
@@ -216,7 +275,7 @@ L1:
 	}
 
 	if testModeEnabled == 1 {
-		utilities.SendMessage("done "+self, tester)
+		utilities.SendMessage(utilities.Done{Client: self}, tester)
 	}
 
 	logMutex.RLock()
@@ -232,160 +291,671 @@ L1:
 
 }
 
-//put messages into queue (mutually exclusive queue access)
-func producer(connection net.Conn) {
-	for {
-		b := make([]byte, 512)
-		connection.Read(b)
-		s := string(b)
-		spl := strings.Split(s, " ")
-		if utilities.ZeroByteArray(b) {
-			continue
+//runs one query and waits for its result, the query's keyword may end in "!" if it was sent asynchronously
+//returns the error if the request ultimately failed
+func execute(query string, identifier string) error {
+	spl := strings.Split(query, " ")
+	keyword := strings.TrimSuffix(utilities.TrimString(spl[0]), "!")
+	var failure error
+	var err error
+
+	switch keyword {
+	case "get":
+		//eventual, sequential and linearizable will get from random replica (be sure to print which one)
+		//in linearizable mode the replica checks the primary's read index before answering
+		//in eventual mode a replica behind this client's session passes the read on to the primary
+		//chain will get from the tail, the last replica, which only has writes every replica has applied
+		//either way only the group owning the key is asked
+		//a staleness bound (maxstaleness=2s or maxversions=N) makes a replica that is further behind pass the read on to the primary
+		var options []string
+		if len(spl) >= 3 && strings.Contains(spl[len(spl)-1], "=") {
+			if bound := stalenessBound(spl[len(spl)-1]); bound != "" {
+				options = append(options, bound)
+			}
+			spl = spl[:len(spl)-1]
 		}
-		bufferMutex.Lock()
-		fmt.Print("message received: " + s + "\n")
-		//acknowledgements from replicas have highest priority to prevent deadlock
-		if spl[0] == "replica-set-result" || spl[0] == "get-result" || spl[0] == "primary-set-result" {
-			messageBuffer = append([]string{s}, messageBuffer...)
+		//if the first replica doesn't answer the read fails over to the others, then the primary
+		var destination string
+		group := groupFor(spl[1])
+		if len(group.Replicas) == 0 {
+			//with every replica removed the primary is the only copy left
+			destination = group.Primary
+		} else if consistency == "chain" {
+			destination = group.Replicas[len(group.Replicas)-1]
 		} else {
-			messageBuffer = append(messageBuffer, s)
+			var idx int
+			if len(spl) < 3 {
+				idx = rand.Intn(len(group.Replicas))
+			} else {
+				//optional argument to specify which replica to access
+				//added for ease of testing
+				idx, err = strconv.Atoi(spl[2])
+				if err != nil || idx < 0 || idx >= len(group.Replicas) {
+					idx = rand.Intn(len(group.Replicas))
+				}
+			}
+			destination = group.Replicas[idx]
+		}
+		destinations := []string{destination}
+		if consistency != "chain" {
+			for _, replica := range group.Replicas {
+				if replica != destination {
+					destinations = append(destinations, replica)
+				}
+			}
 		}
-		bufferMutex.Unlock()
-		time.Sleep(time.Second)
+		if destination != group.Primary {
+			destinations = append(destinations, group.Primary)
+		}
+
+		//waiting on resp...
+		request := utilities.GetRequest{Key: spl[1], ReplyTo: self, ID: identifier, Dependency: sessionToken(spl[1]), Options: options}
+		_, failure = sendAndWait(request, destinations, identifier)
+
+	case "set":
+		if consistency == "multiwriter" {
+			//any worker of the group takes the write, along with the context of the siblings this client last saw
+			group := groupFor(spl[1])
+			workers := append([]string{group.Primary}, group.Replicas...)
+			contextsMutex.RLock()
+			request := utilities.MultiSetRequest{Key: spl[1], Value: spl[2], ReplyTo: self, ID: identifier, Context: contexts[spl[1]]}
+			contextsMutex.RUnlock()
+			_, failure = sendAndWait(request, []string{workers[rand.Intn(len(workers))]}, identifier)
+			break
+		}
+		//clientside logic is same across all consistencies, set to the primary and wait for response
+		//in causal mode the write carries what this client has seen, so replicas apply it after those writes
+		dependency := 0
+		if consistency == "causal" {
+			dependency = sessionToken(spl[1])
+		}
+		request := utilities.SetRequest{Key: spl[1], Value: spl[2], ReplyTo: self, ID: identifier, Dependency: dependency}
+		_, failure = sendAndWait(request, []string{groupFor(spl[1]).Primary}, identifier)
+	case "ginc", "inc", "dec", "sadd", "srem", "lwwset":
+		//convergent values: any worker of the group takes the update and passes it on to the others
+		//counters take an optional amount, 1 if missing. sets take an element and registers a value, neither has a default
+		argument := "1"
+		if len(spl) >= 3 {
+			argument = spl[2]
+		} else if keyword != "ginc" && keyword != "inc" && keyword != "dec" {
+			failure = errors.New(keyword + " takes a KEY and a value, got no value")
+			break
+		}
+		group := groupFor(spl[1])
+		workers := append([]string{group.Primary}, group.Replicas...)
+		request := utilities.CRDTUpdate{Key: spl[1], Op: keyword, Argument: argument, ReplyTo: self, ID: identifier}
+		_, failure = sendAndWait(request, []string{workers[rand.Intn(len(workers))]}, identifier)
+	case "cget":
+		//reads a convergent value from a random worker of the group, failing over to the others
+		group := groupFor(spl[1])
+		workers := append([]string{group.Primary}, group.Replicas...)
+		rand.Shuffle(len(workers), func(i, j int) { workers[i], workers[j] = workers[j], workers[i] })
+		_, failure = sendAndWait(utilities.CRDTGet{Key: spl[1], ReplyTo: self, ID: identifier}, workers, identifier)
+	case "txn":
+		//atomic write of several keys, which may be owned by different groups or clusters
+		failure = runTransaction(spl[1:])
+	case "migrate":
+		//admin command, moves every key starting with spl[1] to the cluster whose primary is spl[2]
+		//optional third argument is the index of the group to move keys out of, defaults to the first
+		idx := 0
+		if len(spl) >= 4 {
+			idx, err = strconv.Atoi(spl[3])
+			if err != nil || idx < 0 || idx >= len(groups) {
+				idx = 0
+			}
+		}
+		groupsMutex.RLock()
+		destination := groups[idx].Primary
+		groupsMutex.RUnlock()
+		//not retried, the primary may still be moving keys after the timeout
+		failure = utilities.SendMessage(utilities.MigrateRequest{Prefix: spl[1], NewPrimary: spl[2], ReplyTo: self, ID: identifier}, destination)
+		if failure == nil {
+			var response utilities.Message
+			response, failure = waitForSingleResponse(identifier, requestTimeout)
+			//an aborted migration leaves the keys where they were
+			if rejection, rejected := response.(utilities.ErrorReply); rejected {
+				failure = rejection
+			}
+		}
+	case "add-replica", "remove-replica":
+		//admin commands, the primary bootstraps or drops the replica and tells every client
+		//optional second argument is the index of the group to change, defaults to the first
+		idx := 0
+		if len(spl) >= 3 {
+			idx, err = strconv.Atoi(spl[2])
+			if err != nil || idx < 0 || idx >= len(groups) {
+				idx = 0
+			}
+		}
+		groupsMutex.RLock()
+		destination := groups[idx].Primary
+		groupsMutex.RUnlock()
+		request := utilities.ReconfigureRequest{Operation: strings.TrimSuffix(keyword, "-replica"), Address: spl[1], ReplyTo: self, ID: identifier}
+		failure = utilities.SendMessage(request, destination)
+		if failure == nil {
+			var response utilities.Message
+			response, failure = waitForSingleResponse(identifier, requestTimeout)
+			//a replica that never acked its copy of the store isn't added
+			if rejection, rejected := response.(utilities.ErrorReply); rejected {
+				failure = rejection
+			}
+		}
+	case "wait":
+		delta, _ := strconv.Atoi(utilities.TrimString(spl[1]))
+		for delta > 0 {
+			time.Sleep(time.Second)
+			delta--
+		}
+
 	}
+	return failure
+}
 
+//sends query without waiting for its result, the repl goes on to the next line while it is outstanding
+//once it finishes its completion is printed and logged with its latency, measured from when it was read
+func sendAsync(query string, identifier string, startTime int64) {
+	outstandingMutex.Lock()
+	outstanding[identifier] = query
+	outstandingMutex.Unlock()
+	outstandingGroup.Add(1)
+	fmt.Print("SENT " + identifier + ": " + query + "\n")
+
+	go func() {
+		defer outstandingGroup.Done()
+		failure := execute(query, identifier)
+		outstandingMutex.Lock()
+		delete(outstanding, identifier)
+		outstandingMutex.Unlock()
+		latency := finish(query, startTime, failure)
+		fmt.Print("COMPLETED " + identifier + " (LATENCY: " + strconv.Itoa(int(latency)) + " ms): " + query + "\n")
+	}()
 }
 
-//func to initiate one producer per socket
-func producerWrapper(listener net.Listener) {
-	for {
-		connection, err := listener.Accept()
-		if err != nil {
-			panic(err)
-		}
-		go producer(connection)
+//logs that query finished, with an ERROR line first if it failed, and returns its latency
+func finish(query string, startTime int64, failure error) int64 {
+	endTime := utilities.GetTimeInMillis()
+	logMutex.Lock()
+	defer logMutex.Unlock()
+	if failure != nil {
+		fmt.Print("ERROR: " + failure.Error() + "\n")
+		log += "ERROR: " + failure.Error() + ": " + query + "\n"
 	}
+	log += "FINISHED @ " + strconv.Itoa(int(startTime)) + " (LATENCY: " + strconv.Itoa(int(endTime-startTime)) + " ms): " + query + "\n"
+	return endTime - startTime
 }
 
-//consume messages from queue (mutually exclusive queue access)
+//puts a message from one of the pooled connections into the queue
+//a message that isn't valid is dropped here, so handlers only ever get messages with every field they read
+func producer(message utilities.Message, sender string) {
+	if err := utilities.ValidateMessage(message); err != nil {
+		fmt.Print("message dropped: " + err.Error() + "\n")
+		return
+	}
+	fmt.Print("message received: " + message.String() + "\n")
+	//results come first so the request waiting on them finishes
+	switch message.(type) {
+	case utilities.GetResult, utilities.SetResult, utilities.ReplicateAck, utilities.Redirect, utilities.ErrorReply:
+		messages.Push(message, utilities.PriorityAck)
+	default:
+		messages.Push(message, utilities.PriorityRequest)
+	}
+}
+
+//consume messages from queue, blocking while it is empty
 func consumer() {
 	for {
-		bufferMutex.Lock()
-		len := len(messageBuffer)
-		//should've used two semaphores instead of busywaiting... ¯\_(ツ)_/¯
-		if len == 0 {
-			bufferMutex.Unlock()
-			time.Sleep(time.Second / 2)
-			continue
-		}
-
-		s := utilities.TrimString(messageBuffer[0])
-		messageBuffer = messageBuffer[1:]
-		bufferMutex.Unlock()
-
-		split := strings.Split(s, " ")
-		switch split[0] {
-		case "initialize":
-			initialize(s)
-		case "get-result":
-			getResult(s)
-		case "primary-set-result":
-			primarySetResult(s)
+		message := messages.Pop()
+
+		switch m := message.(type) {
+		case utilities.Initialize:
+			initialize(m)
+		case utilities.GetResult:
+			observe(m.Key, m.Version)
+			addResponse(m.ID, m)
+		case utilities.SetResult:
+			observe(m.Key, m.Index)
+			addResponse(m.ID, m)
+		case utilities.Siblings:
+			multiResult(m.Key, m.Context, m)
+		case utilities.MultiSetResult:
+			multiResult(m.Key, m.Clock, m)
+		case utilities.CRDTResult, utilities.SetConflict, utilities.IncrError, utilities.PrepareResult, utilities.DecisionResult,
+			utilities.ReconfigureResult, utilities.MigrateResult, utilities.ErrorReply:
+			//every other reply is only the response of the request with its identifier
+			_, identifier := utilities.ReplyTarget(m)
+			addResponse(identifier, m)
+		case utilities.TxnStatus:
+			go txnStatus(m)
+		case utilities.Redirect:
+			redirect(m)
+		case utilities.ReplicasUpdate:
+			replicasUpdate(m)
+		case utilities.VersionMismatch:
+			fmt.Print("warning: " + m.String() + "\n")
 		}
 
 	}
 }
 
 //initialization strategy after getting first message from tester
-/* Expected syntax of message:
-initialize __ROLE__
-__CONSISTENCY__
-REPLICA1 REPLICA2 REPLICA3 REPLICA4 ...
-__SELF__
-__TESTER__
-__PRIMARY__
-
-explanation:
-initialize is the tag, role can be either "primary" or "replica"
-consistency can be either "eventual", "consistent", or "linearizable"
-replica1 ... is IP:LISTENINGPORT for various replicas
+/* Fields of the message:
+role is "client"
+consistency can be either "eventual", "causal", "consistent", "linearizable", "chain", or "multiwriter"
+replicas are IP:LISTENINGPORT for various replicas
 self is IP:LISTENINGPORT for current proc
 tester is IP:LISTENINGPORT for the tester coordinating this runthrough
 primary is IP:LISTENINGPORT for the primary (can be same as self)
+testmode is set if the client should read its instruction file, else it reads stdin
+each group is a primary and its replicas, if there are none the primary and replicas are the only group
 */
-func initialize(message string) {
-	splitLines := strings.Split(message, "\n")
-	firstLineSplit := strings.Split(splitLines[0], " ")
-
-	role = firstLineSplit[1]
-	consistency = splitLines[1]
-	replicas = strings.Split(splitLines[2], " ")
-	replicas = replicas[:len(replicas)-1]
-	self = splitLines[3]
-	tester = splitLines[4]
-	primary = splitLines[5]
+func initialize(initialize utilities.Initialize) {
+	consistency = initialize.Consistency
+	self = initialize.Self
+	pool.SetSelf(self)
+	clientID = utilities.RemoveColon(self) + "." + fmt.Sprint(utilities.GetTimeInMillis())
+	tester = initialize.Tester
+
+	groupsMutex.Lock()
+	groups = initialize.Groups
+	if len(groups) == 0 {
+		groups = []utilities.Group{{Primary: initialize.Primary, Replicas: initialize.Replicas}}
+	}
+	ring = utilities.NewHashRing(groups)
+	groupsMutex.Unlock()
 
 	testModeEnabledMutex.Lock()
-	testModeEnabled, _ = strconv.Atoi(utilities.TrimString(splitLines[6]))
+	testModeEnabled = 0
+	if initialize.TestMode {
+		testModeEnabled = 1
+	}
 	testModeEnabledMutex.Unlock()
 
 	instrFileMutex.Lock()
-	instrFile = utilities.RemoveColon("../../input_files/client_inputs/" + self)
+	instrFile = "../../input_files/client_inputs/" + utilities.RemoveColon(self)
 	instrFileMutex.Unlock()
 
+	go recoverTransactions()
+
+	//set last, the repl waits on role before reading groups
+	role = initialize.Role
 }
 
 //testing function
 func printParse() {
-	for _, worker := range replicas {
-		fmt.Print("Replica initialized: " + worker + "\n")
+	for _, group := range groups {
+		fmt.Print("Primary initialized: " + group.Primary + "\n")
+		for _, worker := range group.Replicas {
+			fmt.Print("Replica initialized: " + worker + "\n")
+		}
 	}
 
-	fmt.Print("Primary initialized: " + primary + "\n")
 	fmt.Print("Tester initialized: " + tester + "\n")
 	fmt.Print("Consistency: " + consistency + "\n")
 }
 
-func getResult(message string) {
-	spl := strings.Split(message, " ")
-	identifier := utilities.TrimString(spl[3])
+//stores a reply as a response to the request with identifier, waking whoever waits on it
+func addResponse(identifier string, message utilities.Message) {
 	responseMutex.Lock()
 	responses[identifier] = append(responses[identifier], message)
 	responseMutex.Unlock()
+	responsesChanged.Notify()
 }
 
-func primarySetResult(message string) {
-	spl := strings.Split(message, " ")
-	identifier := utilities.TrimString(spl[3])
-	responseMutex.Lock()
-	responses[identifier] = append(responses[identifier], message)
-	responseMutex.Unlock()
+//replies in multiwriter mode (Siblings and MultiSetResult), both carry the key's new context
+func multiResult(key string, context utilities.VectorClock, message utilities.Message) {
+	contextsMutex.Lock()
+	contexts[key] = context
+	contextsMutex.Unlock()
+	_, identifier := utilities.ReplyTarget(message)
+	addResponse(identifier, message)
+}
+
+//turns a get's staleness option into the option sent to the replica, or "" if it can't be parsed
+//maxstaleness takes a duration such as 2s or 500ms and is sent in millis, maxversions takes a count
+func stalenessBound(option string) string {
+	spl := strings.Split(option, "=")
+	switch spl[0] {
+	case "maxstaleness":
+		d, err := time.ParseDuration(spl[1])
+		if err != nil {
+			return ""
+		}
+		return "maxstaleness=" + strconv.FormatInt(d.Milliseconds(), 10)
+	case "maxversions":
+		n, err := strconv.Atoi(spl[1])
+		if err != nil {
+			return ""
+		}
+		return "maxversions=" + strconv.Itoa(n)
+	}
+	return ""
 }
 
-func waitForSingleResponse(identifier string) {
+//returns a request id no other request from any client has
+func nextRequestID() string {
+	sequenceMutex.Lock()
+	defer sequenceMutex.Unlock()
+	sequence++
+	return utilities.RequestID(clientID, sequence)
+}
+
+//raises the dependency on key's group to a write index from a reply about key
+func observe(key string, index int) {
+	primary := groupFor(key).Primary
+	dependencyMutex.Lock()
+	if index > dependencies[primary] {
+		dependencies[primary] = index
+	}
+	dependencyMutex.Unlock()
+}
+
+//dependency sent with get messages for key in causal and eventual mode, and with primary-set messages in causal mode
+//it is the highest index seen from the group owning key, a replica of that group can catch up to it
+//a token covering other groups' writes would have every replica of this group look behind, and pass every read on to the primary
+func sessionToken(key string) int {
+	if consistency != "causal" && consistency != "eventual" {
+		return 0
+	}
+	primary := groupFor(key).Primary
+	dependencyMutex.RLock()
+	defer dependencyMutex.RUnlock()
+	return dependencies[primary]
+}
+
+//sent by the primary whenever a replica is added or removed
+func replicasUpdate(update utilities.ReplicasUpdate) {
+	groupsMutex.Lock()
+	for i := range groups {
+		if groups[i].Primary == update.Primary {
+			groups[i].Replicas = update.Replicas
+		}
+	}
+	groupsMutex.Unlock()
+}
+
+//sent instead of a result when the key's prefix was migrated to another cluster
+//the new owner is remembered so later requests for the prefix go straight there
+func redirect(message utilities.Redirect) {
+	groupsMutex.Lock()
+	redirects[message.Prefix] = message.NewPrimary
+	groupsMutex.Unlock()
+
+	addResponse(message.ID, message)
+}
+
+//returns the group owning key: a migrated prefix's new primary if there is one, else the hash ring's pick
+//only the primary of another cluster is known, so redirected keys are read from and written to it
+func groupFor(key string) utilities.Group {
+	groupsMutex.RLock()
+	defer groupsMutex.RUnlock()
+	for prefix, newPrimary := range redirects {
+		if strings.HasPrefix(key, prefix) {
+			return utilities.Group{Primary: newPrimary}
+		}
+	}
+	return groups[ring.Lookup(key)]
+}
+
+//number of redirects followed before giving up on a request, guards against two clusters pointing at each other
+const maxRedirects = 5
+
+//how long to wait for a response before trying again, generous since blocking writes sleep 5 seconds per replica
+const requestTimeout = 30 * time.Second
+
+//attempts made at a request before giving up on it
+const maxAttempts = 4
+
+//wait before the first retry, doubled after every retry after that
+const retryBackoff = time.Second
+
+//sends message and waits for the response carrying identifier
+//if there is no response within requestTimeout the message is sent again, to the next of destinations,
+//waiting longer before each retry. retrying a write is safe since the primary applies each request id once
+//on a redirect the same message is sent again to the key's new owner
+func sendAndWait(message utilities.Message, destinations []string, identifier string) (utilities.Message, error) {
+	attempt := 0
+	backoff := retryBackoff
+	for redirects := 0; redirects <= maxRedirects; {
+		destination := destinations[attempt%len(destinations)]
+		var response utilities.Message
+		err := utilities.SendMessage(message, destination)
+		if err == nil {
+			response, err = waitForSingleResponse(identifier, requestTimeout)
+		}
+		if err != nil {
+			attempt++
+			if attempt == maxAttempts {
+				return nil, errors.New("no response after " + strconv.Itoa(maxAttempts) + " attempts, last one to " + destination + ": " + err.Error())
+			}
+			time.Sleep(backoff)
+			backoff *= 2
+			continue
+		}
+
+		if rejection, rejected := response.(utilities.ErrorReply); rejected {
+			//the worker couldn't handle the request, sending it again wouldn't help
+			return nil, rejection
+		}
+		moved, isRedirect := response.(utilities.Redirect)
+		if !isRedirect {
+			return response, nil
+		}
+		message = redirected(message)
+		destinations = []string{moved.NewPrimary}
+		attempt = 0
+		redirects++
+		responseMutex.Lock()
+		delete(responses, identifier)
+		responseMutex.Unlock()
+	}
+	return nil, errors.New("followed " + strconv.Itoa(maxRedirects) + " redirects without reaching the key's owner")
+}
+
+//message with its dependency recomputed for the key's new owner, to send it on after a redirect
+//redirect has recorded the new owner by then. the old dependency is a write index of the cluster the key moved away from,
+//the new owner's replicas could wait forever for it
+func redirected(message utilities.Message) utilities.Message {
+	switch request := message.(type) {
+	case utilities.GetRequest:
+		request.Dependency = sessionToken(request.Key)
+		return request
+	case utilities.SetRequest:
+		if consistency == "causal" {
+			request.Dependency = sessionToken(request.Key)
+		}
+		return request
+	}
+	return message
+}
+
+//blocks until a response for identifier arrives or timeout passes, logs it and returns it
+func waitForSingleResponse(identifier string, timeout time.Duration) (utilities.Message, error) {
+	received, err := waitForResponses(identifier, 1, timeout)
+	if err != nil {
+		return nil, err
+	}
+	return received[0], nil
+}
+
+//blocks until n responses for identifier arrive, logs them and returns them
+//if timeout passes first, returns the responses that did arrive and an error
+func waitForResponses(identifier string, n int, timeout time.Duration) ([]utilities.Message, error) {
+	var received []utilities.Message
+	deadline := time.Now().Add(timeout)
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
 	waiting := true
 	for waiting {
+		changed := responsesChanged.Changed()
 		responseMutex.RLock()
 		count := len(responses[identifier])
 		responseMutex.RUnlock()
 
-		if count >= 1 {
+		if count < n && time.Now().After(deadline) {
+			responseMutex.RLock()
+			received = append(received, responses[identifier][:count]...)
+			responseMutex.RUnlock()
+			return received, errors.New("timed out waiting for " + identifier)
+		}
+
+		if count >= n {
 			/*
 				responseMutex.RLock()
 				fmt.Print("Response received: " + responses[identifier][0])
 				responseMutex.RUnlock()
 			*/
+			responseMutex.RLock()
+			received = append(received, responses[identifier][:n]...)
+			responseMutex.RUnlock()
+
 			logMutex.Lock()
-			log += "RECEIVED: " + responses[identifier][0] + "\n"
+			for _, response := range received {
+				log += "RECEIVED: " + response.String() + "\n"
+			}
 			logMutex.Unlock()
 
 			waiting = false
 			continue
 		}
 
-		time.Sleep(time.Second / 2)
+		select {
+		case <-changed:
+		case <-timer.C:
+		}
 	}
+	return received, nil
+}
+
+//coordinates a two-phase commit writing the pairs in args ("K1 V1 K2 V2 ...") to the primaries owning each key
+//the decision is logged to disk before any participant hears it, and the end of the transaction is logged once all have acked
+//a participant that doesn't vote within requestTimeout counts as a no. if some don't ack the decision, the end isn't
+//logged, so the decision is sent again when the client restarts
+func runTransaction(args []string) error {
+	if len(args) == 0 || len(args)%2 != 0 {
+		return errors.New("txn takes KEY VALUE pairs, got " + strconv.Itoa(len(args)) + " arguments")
+	}
+	txid := utilities.RemoveColon(self) + "-" + fmt.Sprint(time.Now().UnixNano())
+
+	//grouping the writes by the primary that owns each key
+	writes := map[string]*utilities.Prepare{}
+	var participants []string
+	for i := 0; i+1 < len(args); i += 2 {
+		participant := groupFor(args[i]).Primary
+		if _, exists := writes[participant]; !exists {
+			participants = append(participants, participant)
+			writes[participant] = &utilities.Prepare{ID: txid, ReplyTo: self}
+		}
+		writes[participant].Keys = append(writes[participant].Keys, args[i])
+		writes[participant].Values = append(writes[participant].Values, args[i+1])
+	}
+	if len(participants) == 0 {
+		return nil
+	}
+
+	txnMutex.Lock()
+	activeTxns[txid] = true
+	txnMutex.Unlock()
+
+	for _, participant := range participants {
+		utilities.SendMessage(*writes[participant], participant)
+	}
+	decision := "commit"
+	votes, err := waitForResponses(txid, len(participants), requestTimeout)
+	if err != nil {
+		decision = "abort"
+	}
+	for _, vote := range votes {
+		if result, isVote := vote.(utilities.PrepareResult); !isVote || result.Vote != "yes" {
+			decision = "abort"
+		}
+	}
+	responseMutex.Lock()
+	delete(responses, txid)
+	responseMutex.Unlock()
+
+	logDecision(decision, txid, participants)
+	txnMutex.Lock()
+	decisions[txid] = decision
+	delete(activeTxns, txid)
+	txnMutex.Unlock()
+
+	for _, participant := range participants {
+		utilities.SendMessage(utilities.Decision{Decision: decision, ID: txid, ReplyTo: self}, participant)
+	}
+	if _, err := waitForResponses(txid, len(participants), requestTimeout); err != nil {
+		return errors.New("transaction " + txid + " decided " + decision + " but not every participant acked")
+	}
+	logDecision("done", txid, participants)
+	return nil
+}
+
+//sent by a participant whose transaction has been prepared for too long
+//a transaction with no logged decision that isn't collecting votes anymore is presumed aborted
+func txnStatus(message utilities.TxnStatus) {
+	txid := message.ID
+	participant := message.ReplyTo
 
+	txnMutex.RLock()
+	decision, decided := decisions[txid]
+	active := activeTxns[txid]
+	txnMutex.RUnlock()
+
+	if active {
+		return
+	}
+	if !decided {
+		decision = "abort"
+	}
+	utilities.SendMessage(utilities.Decision{Decision: decision, ID: txid, ReplyTo: self}, participant)
+}
+
+//path of this client's transaction decision log, one line per record: "DECISION TXID PARTICIPANT1 PARTICIPANT2 ..."
+func txlogPath() string {
+	return "../../output_files/" + utilities.RemoveColon(self) + ".txlog"
+}
+
+//appends a record to the decision log and waits for it to reach the disk
+func logDecision(decision string, txid string, participants []string) {
+	out, err := os.OpenFile(txlogPath(), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		fmt.Print("Error writing transaction log\n")
+		panic(err)
+	}
+	out.WriteString(decision + " " + txid + " " + strings.Join(participants, " ") + "\n")
+	out.Sync()
+	out.Close()
+}
+
+//reads the decision log left by an earlier run of this client
+//transactions that were decided but never finished get their decision sent again, participants ack as usual
+func recoverTransactions() {
+	data, err := os.ReadFile(txlogPath())
+	if err != nil {
+		return
+	}
+	unfinished := map[string][]string{}
+	for _, line := range strings.Split(string(data), "\n") {
+		spl := strings.Split(line, " ")
+		if len(spl) < 2 {
+			continue
+		}
+		if spl[0] == "done" {
+			delete(unfinished, spl[1])
+			continue
+		}
+		txnMutex.Lock()
+		decisions[spl[1]] = spl[0]
+		txnMutex.Unlock()
+		unfinished[spl[1]] = spl[2:]
+	}
+	for txid, participants := range unfinished {
+		txnMutex.RLock()
+		decision := decisions[txid]
+		txnMutex.RUnlock()
+		for _, participant := range participants {
+			utilities.SendMessage(utilities.Decision{Decision: decision, ID: txid, ReplyTo: self}, participant)
+		}
+	}
 }
 
 /*
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"DistKV/src/kvclient"
+	"DistKV/src/utilities"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/**
+Project: DistKV Store
+
+gateway.go
+*/
+
+//how long the gateway works on one http request before answering 504, retries included
+const gatewayTimeout = 60 * time.Second
+
+//connection to the cluster shared by every http request
+var client *kvclient.Client
+
+//body of a PUT, and of a successful GET
+type entry struct {
+	Key   string `json:"key,omitempty"`
+	Value string `json:"value"`
+}
+
+//body of every error response
+type failure struct {
+	Error string `json:"error"`
+}
+
+//arguments: port to listen on, the cluster's consistency, then one argument per replica group:
+//its primary followed by its replicas, comma separated ("localhost:9000,localhost:9001,localhost:9004")
+//-listen serves http on another address than localhost:PORT, the port can then be left out
+//-advertise is the host workers reach the gateway at, needed when they run on other machines
+func main() {
+	listen := flag.String("listen", "", "address to serve http on instead of localhost:PORT (\":8080\", \"[::1]:8080\", \"unix:/tmp/gateway.sock\")")
+	advertise := flag.String("advertise", "", "host workers send responses to, responses are listened for on every interface if set")
+	flag.Parse()
+	args := flag.Args()
+	//no consistency mode is a number, so a first arg that is one is the port
+	if len(args) > 0 {
+		if port, err := strconv.Atoi(args[0]); err == nil {
+			if port < 0 || port >= 65535 {
+				panic(args[0] + " is not a valid port")
+			}
+			if *listen == "" {
+				*listen = "localhost:" + strconv.Itoa(port)
+			}
+			args = args[1:]
+		}
+	}
+	if len(args) < 2 || *listen == "" {
+		fmt.Fprintf(os.Stderr, "Error: takes port (optional with -listen), consistency and at least one group (PRIMARY,REPLICA1,REPLICA2,...)\n")
+		os.Exit(1)
+	}
+	var groups []utilities.Group
+	for _, arg := range args[1:] {
+		spl := strings.Split(arg, ",")
+		groups = append(groups, utilities.Group{Primary: spl[0], Replicas: spl[1:]})
+	}
+
+	config := kvclient.Config{Groups: groups, Consistency: args[0]}
+	if *advertise != "" {
+		config.Listen = ":0"
+		config.Advertise = net.JoinHostPort(*advertise, "0")
+	}
+	var err error
+	client, err = kvclient.New(config)
+	if err != nil {
+		panic(err)
+	}
+
+	listener, err := utilities.Listen(*listen)
+	if err != nil {
+		panic(err)
+	}
+	http.HandleFunc("/kv/", handleKey)
+	fmt.Print("** Gateway listening on " + utilities.ListenerAddress(listener) + " **\n")
+	panic(http.Serve(listener, nil))
+}
+
+//GET /kv/KEY?consistency=strong|eventual&maxstaleness=2s&maxversions=N
+//    200 {"key": KEY, "value": VALUE}, or 404 if the key has no value, 409 in multiwriter mode if it has several
+//PUT /kv/KEY with body {"value": VALUE}
+//    200 {"key": KEY, "value": VALUE}, or 409 if the key is locked by a transaction
+//DELETE /kv/KEY
+//    204 with no body, or 409 if the key is locked by a transaction
+//400 for a bad key, value or parameter, 504 if the cluster didn't answer in time, 502 for anything else
+//every error has the body {"error": MESSAGE}
+func handleKey(w http.ResponseWriter, r *http.Request) {
+	key := strings.TrimPrefix(r.URL.Path, "/kv/")
+	ctx, cancel := context.WithTimeout(r.Context(), gatewayTimeout)
+	defer cancel()
+
+	switch r.Method {
+	case http.MethodGet:
+		options, err := readOptions(r)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, failure{err.Error()})
+			return
+		}
+		value, err := client.GetWithOptions(ctx, key, options)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, entry{key, value})
+	case http.MethodPut:
+		var body entry
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeJSON(w, http.StatusBadRequest, failure{"body must be {\"value\": VALUE}: " + err.Error()})
+			return
+		}
+		if err := client.Set(ctx, key, body.Value); err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, entry{key, body.Value})
+	case http.MethodDelete:
+		if err := client.Delete(ctx, key); err != nil {
+			writeError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("Allow", "GET, PUT, DELETE")
+		writeJSON(w, http.StatusMethodNotAllowed, failure{r.Method + " is not supported"})
+	}
+}
+
+//read options from a GET's query parameters, all of them optional
+func readOptions(r *http.Request) (kvclient.ReadOptions, error) {
+	query := r.URL.Query()
+	options := kvclient.ReadOptions{Consistency: query.Get("consistency")}
+	if options.Consistency != "" && options.Consistency != "strong" && options.Consistency != "eventual" {
+		return options, errors.New("consistency must be strong or eventual")
+	}
+	if x := query.Get("maxstaleness"); x != "" {
+		d, err := time.ParseDuration(x)
+		if err != nil || d <= 0 {
+			return options, errors.New("maxstaleness must be a duration such as 2s or 500ms")
+		}
+		options.MaxStaleness = d
+	}
+	if x := query.Get("maxversions"); x != "" {
+		n, err := strconv.Atoi(x)
+		if err != nil || n <= 0 {
+			return options, errors.New("maxversions must be a positive number")
+		}
+		options.MaxVersions = n
+	}
+	return options, nil
+}
+
+//answers with the status code matching a kvclient error
+func writeError(w http.ResponseWriter, err error) {
+	status := http.StatusBadGateway
+	switch {
+	case errors.Is(err, kvclient.ErrNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, kvclient.ErrConflict), errors.Is(err, kvclient.ErrSiblings):
+		status = http.StatusConflict
+	case errors.Is(err, kvclient.ErrInvalid):
+		status = http.StatusBadRequest
+	case errors.Is(err, kvclient.ErrTimeout), errors.Is(err, context.DeadlineExceeded):
+		status = http.StatusGatewayTimeout
+	}
+	writeJSON(w, status, failure{err.Error()})
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,547 @@
+package kvclient
+
+import (
+	"DistKV/src/utilities"
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//returned by Get for a key that has never been set or was deleted
+var ErrNotFound = errors.New("key not found")
+
+//returned by Set and Delete when the key is locked by a prepared transaction, the write can be tried again later
+var ErrConflict = errors.New("key is locked by a transaction")
+
+//returned by Incr when the key's value isn't an integer
+var ErrNotInteger = errors.New("value is not an integer")
+
+//wrapped in the error of a request that got no response after every attempt
+var ErrTimeout = errors.New("no response")
+
+//returned for keys or values workers don't take: empty, or containing whitespace
+//and by Set for the value NULL, which is what a deleted key reads as
+var ErrInvalid = errors.New("keys and values must be non-empty and can't contain whitespace, and a value can't be NULL")
+
+//returned by requests made after Close
+var ErrClosed = errors.New("client is closed")
+
+//returned by Get in multiwriter mode when concurrent writes left the key with several values, GetSiblings returns them all
+var ErrSiblings = errors.New("key has several values from concurrent writes")
+
+//returned by Incr in multiwriter mode, where there is no single copy of a value to add to
+var ErrUnsupported = errors.New("not supported in this consistency mode")
+
+//wrapped in the error of a request a worker answered with an error message, which says what was wrong with it
+//the request isn't retried, it would be rejected again
+var ErrRejected = errors.New("request rejected")
+
+//number of redirects followed before giving up on a request, guards against two clusters pointing at each other
+const maxRedirects = 5
+
+//value a delete writes, which is also what a worker answers a get of a missing key with
+const tombstone = "NULL"
+
+//where the cluster is and how to talk to it
+type Config struct {
+	//primary and replicas of a cluster with a single replica group
+	Primary  string
+	Replicas []string
+	//every replica group, in the same order as init.txt, for a cluster with several. overrides Primary and Replicas
+	Groups []utilities.Group
+	//"eventual", "causal", "sequential", "linearizable", "chain" or "multiwriter", the mode the workers were initialized with
+	Consistency string
+	//address to listen on for responses, a free port on localhost if empty
+	//any form utilities.Listen takes: ":0" for every interface, one IP ("10.0.0.5:0", "[::1]:0"), or "unix:PATH"
+	Listen string
+	//address workers send responses to, the listener's address if empty. a port of 0 is replaced by the listener's port
+	//needed when listening on every interface, or when workers reach this host by another name
+	Advertise string
+	//how long to wait for a response before sending the request again, 30 seconds if zero
+	RequestTimeout time.Duration
+	//attempts made at a request before giving up on it, 4 if zero
+	MaxAttempts int
+}
+
+//connection to a DistKV cluster, safe for use by several goroutines at once
+//keeps this client's session (the highest write index it has seen from each replica group), so in eventual and causal mode
+//a Get always sees this client's own earlier Sets
+type Client struct {
+	config   Config
+	groups   []utilities.Group
+	ring     *utilities.HashRing
+	listener net.Listener
+	pool     *utilities.Pool
+	//address workers send responses to, Config.Advertise or the listener's address
+	self string
+	//identifies this client in request ids
+	id string
+
+	//protects everything below
+	mutex sync.Mutex
+	//sequence number of the last request
+	sequence int
+	//highest write index seen from each group, from own writes and the versions of values read, keyed by the group's primary
+	dependencies map[string]int
+	//key prefixes migrated to another cluster, mapped to that cluster's primary
+	redirects map[string]string
+	//in multiwriter mode: causal context of each key, from the last get or set of it
+	//a set sent with it overwrites every value that get returned
+	contexts map[string]utilities.VectorClock
+	//requests waiting for a response, request id -> channel the response is put on
+	waiting map[string]chan utilities.Message
+	closed  bool
+}
+
+//starts listening for responses and returns a client for the cluster described by config
+func New(config Config) (*Client, error) {
+	groups := config.Groups
+	if len(groups) == 0 {
+		if config.Primary == "" {
+			return nil, errors.New("config has no primary")
+		}
+		groups = []utilities.Group{{Primary: config.Primary, Replicas: config.Replicas}}
+	}
+	switch config.Consistency {
+	case "eventual", "causal", "sequential", "linearizable", "chain", "multiwriter":
+	default:
+		return nil, errors.New("unsupported consistency " + strconv.Quote(config.Consistency))
+	}
+	if config.Listen == "" {
+		config.Listen = "localhost:0"
+	}
+	if config.RequestTimeout == 0 {
+		config.RequestTimeout = 30 * time.Second
+	}
+	if config.MaxAttempts == 0 {
+		config.MaxAttempts = 4
+	}
+
+	listener, err := utilities.Listen(config.Listen)
+	if err != nil {
+		return nil, err
+	}
+	c := &Client{
+		config:       config,
+		groups:       groups,
+		ring:         utilities.NewHashRing(groups),
+		listener:     listener,
+		self:         config.Advertise,
+		redirects:    map[string]string{},
+		waiting:      map[string]chan utilities.Message{},
+		dependencies: map[string]int{},
+		contexts:     map[string]utilities.VectorClock{},
+	}
+	if c.self == "" {
+		c.self = utilities.ListenerAddress(listener)
+	} else if host, port, err := net.SplitHostPort(c.self); err == nil && port == "0" {
+		_, port, _ = net.SplitHostPort(listener.Addr().String())
+		c.self = net.JoinHostPort(host, port)
+	}
+	c.id = utilities.RemoveColon(c.self) + "." + strconv.FormatInt(utilities.GetTimeInMillis(), 10)
+	c.pool = utilities.NewPool(c.self, utilities.PoolSize, c.deliver)
+	go c.pool.Serve(listener)
+	//the tester only tells the clients it started about replica set changes, this one asks to be told too
+	for _, group := range groups {
+		go c.pool.Send(utilities.WatchReplicas{Client: c.self}, group.Primary)
+	}
+	return c, nil
+}
+
+//stops listening and closes every connection, requests still waiting fail with ErrClosed
+func (c *Client) Close() error {
+	c.mutex.Lock()
+	c.closed = true
+	for identifier, ch := range c.waiting {
+		close(ch)
+		delete(c.waiting, identifier)
+	}
+	c.mutex.Unlock()
+	err := c.listener.Close()
+	c.pool.Close()
+	return err
+}
+
+//how a Get picks the worker it reads from, the zero value reads the way the cluster's consistency mode does
+type ReadOptions struct {
+	//"" for the cluster's mode, "eventual" for any replica without waiting for this client's own writes,
+	//or "strong" for the key's primary, which has applied every write it acknowledged
+	Consistency string
+	//a replica that hasn't had all of the primary's writes for longer than this passes the read on to the primary
+	MaxStaleness time.Duration
+	//a replica missing more than this many of the primary's writes passes the read on to the primary, ignored if zero
+	MaxVersions int
+}
+
+//value of key, or ErrNotFound if it has none
+//read from a random replica of the key's group (the tail in chain mode), failing over to the others and then the primary
+func (c *Client) Get(ctx context.Context, key string) (string, error) {
+	return c.GetWithOptions(ctx, key, ReadOptions{})
+}
+
+//same as Get, reading from the worker options picks
+//in multiwriter mode every worker is read from alike and options are ignored
+func (c *Client) GetWithOptions(ctx context.Context, key string, options ReadOptions) (string, error) {
+	if !valid(key) {
+		return "", ErrInvalid
+	}
+	if c.config.Consistency == "multiwriter" {
+		values, err := c.GetSiblings(ctx, key)
+		if err != nil {
+			return "", err
+		}
+		if len(values) > 1 {
+			return "", ErrSiblings
+		}
+		return values[0], nil
+	}
+	group := c.groupFor(key)
+	var destinations []string
+	switch {
+	case options.Consistency == "strong":
+	case options.Consistency != "" && options.Consistency != "eventual":
+		return "", errors.New("unsupported read consistency " + strconv.Quote(options.Consistency))
+	case c.config.Consistency == "chain" && options.Consistency == "" && len(group.Replicas) > 0:
+		destinations = append(destinations, group.Replicas[len(group.Replicas)-1])
+	default:
+		for _, i := range rand.Perm(len(group.Replicas)) {
+			destinations = append(destinations, group.Replicas[i])
+		}
+	}
+	destinations = append(destinations, group.Primary)
+
+	get := utilities.GetRequest{Key: key, ReplyTo: c.self}
+	if options.Consistency == "" {
+		get.Dependency = c.sessionToken(group.Primary)
+	}
+	if options.MaxStaleness > 0 {
+		get.Options = append(get.Options, "maxstaleness="+strconv.FormatInt(options.MaxStaleness.Milliseconds(), 10))
+	}
+	if options.MaxVersions > 0 {
+		get.Options = append(get.Options, "maxversions="+strconv.Itoa(options.MaxVersions))
+	}
+	response, err := c.request(ctx, destinations, func(identifier string) utilities.Message {
+		get.ID = identifier
+		return get
+	})
+	if err != nil {
+		return "", err
+	}
+	result, isResult := response.(utilities.GetResult)
+	if !isResult {
+		return "", errors.New("unexpected answer to a get: " + response.String())
+	}
+	//a redirect may have moved the key to another cluster since the request was sent
+	c.observe(c.groupFor(key).Primary, result.Version)
+	if result.Value == tombstone {
+		return "", ErrNotFound
+	}
+	return result.Value, nil
+}
+
+//every value of key in multiwriter mode, sorted, more than one if writes at different workers were concurrent
+//read from a random worker of the key's group, failing over to the others. ErrNotFound if it has none
+//a Set of the key after this overwrites all of them
+func (c *Client) GetSiblings(ctx context.Context, key string) ([]string, error) {
+	if !valid(key) {
+		return nil, ErrInvalid
+	}
+	if c.config.Consistency != "multiwriter" {
+		return nil, ErrUnsupported
+	}
+	group := c.groupFor(key)
+	workers := append([]string{group.Primary}, group.Replicas...)
+	rand.Shuffle(len(workers), func(i, j int) { workers[i], workers[j] = workers[j], workers[i] })
+	response, err := c.request(ctx, workers, func(identifier string) utilities.Message {
+		return utilities.GetRequest{Key: key, ReplyTo: c.self, ID: identifier}
+	})
+	if err != nil {
+		return nil, err
+	}
+	siblings, isSiblings := response.(utilities.Siblings)
+	if !isSiblings {
+		return nil, errors.New("unexpected answer to a get: " + response.String())
+	}
+	c.mutex.Lock()
+	c.contexts[key] = siblings.Context
+	c.mutex.Unlock()
+	//a delete is a write of NULL, concurrent with it the key has the other values
+	var values []string
+	for _, value := range siblings.Values {
+		if value != tombstone {
+			values = append(values, value)
+		}
+	}
+	if len(values) == 0 {
+		return nil, ErrNotFound
+	}
+	sort.Strings(values)
+	return values, nil
+}
+
+//sets key to value at the key's primary, returns once the primary has answered,
+//which in sequential, linearizable and chain mode is after every replica has the write
+//NULL can't be set, it would read back as ErrNotFound
+//in multiwriter mode it is set at a random worker of the key's group instead, see multiSet
+func (c *Client) Set(ctx context.Context, key string, value string) error {
+	if !valid(key) || !valid(value) || value == tombstone {
+		return ErrInvalid
+	}
+	if c.config.Consistency == "multiwriter" {
+		return c.multiSet(ctx, key, value)
+	}
+	_, err := c.write(ctx, key, func(primary string, identifier string) utilities.Message {
+		return utilities.SetRequest{Key: key, Value: value, ReplyTo: c.self, ID: identifier, Dependency: c.writeToken(primary)}
+	})
+	return err
+}
+
+//removes key, a later Get returns ErrNotFound
+//a delete is an ordinary write of the value a worker answers a missing key with, so it replicates and orders like a Set
+func (c *Client) Delete(ctx context.Context, key string) error {
+	if !valid(key) {
+		return ErrInvalid
+	}
+	if c.config.Consistency == "multiwriter" {
+		return c.multiSet(ctx, key, tombstone)
+	}
+	_, err := c.write(ctx, key, func(primary string, identifier string) utilities.Message {
+		return utilities.SetRequest{Key: key, Value: tombstone, ReplyTo: c.self, ID: identifier, Dependency: c.writeToken(primary)}
+	})
+	return err
+}
+
+//adds one to key's value and returns the new value, a key without a value counts as 0
+//the primary reads and writes the value in one step, so concurrent increments are never lost
+func (c *Client) Incr(ctx context.Context, key string) (int, error) {
+	if !valid(key) {
+		return 0, ErrInvalid
+	}
+	if c.config.Consistency == "multiwriter" {
+		return 0, ErrUnsupported
+	}
+	value, err := c.write(ctx, key, func(primary string, identifier string) utilities.Message {
+		return utilities.IncrRequest{Key: key, ReplyTo: c.self, ID: identifier, Dependency: c.writeToken(primary)}
+	})
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(value)
+}
+
+//writes value at a random worker of key's group, failing over to the others
+//the write carries the context of this client's last get or set of the key, so it overwrites the values seen then
+//and is kept alongside any written concurrently with it
+func (c *Client) multiSet(ctx context.Context, key string, value string) error {
+	group := c.groupFor(key)
+	workers := append([]string{group.Primary}, group.Replicas...)
+	rand.Shuffle(len(workers), func(i, j int) { workers[i], workers[j] = workers[j], workers[i] })
+	c.mutex.Lock()
+	seen := c.contexts[key]
+	c.mutex.Unlock()
+	response, err := c.request(ctx, workers, func(identifier string) utilities.Message {
+		return utilities.MultiSetRequest{Key: key, Value: value, ReplyTo: c.self, ID: identifier, Context: seen}
+	})
+	if err != nil {
+		return err
+	}
+	result, isResult := response.(utilities.MultiSetResult)
+	if !isResult {
+		return errors.New("unexpected answer to a write: " + response.String())
+	}
+	c.mutex.Lock()
+	c.contexts[key] = result.Clock
+	c.mutex.Unlock()
+	return nil
+}
+
+//sends a write to the key's primary, build makes the message for that primary and a request id, returns the value written
+func (c *Client) write(ctx context.Context, key string, build func(primary string, identifier string) utilities.Message) (string, error) {
+	group := c.groupFor(key)
+	response, err := c.request(ctx, []string{group.Primary}, func(identifier string) utilities.Message {
+		return build(group.Primary, identifier)
+	})
+	if err != nil {
+		return "", err
+	}
+	switch result := response.(type) {
+	case utilities.SetConflict:
+		return "", ErrConflict
+	case utilities.IncrError:
+		return "", ErrNotInteger
+	case utilities.SetResult:
+		c.observe(c.groupFor(key).Primary, result.Index)
+		return result.Value, nil
+	}
+	return "", errors.New("unexpected answer to a write: " + response.String())
+}
+
+//sends the message build makes for a new request id and waits for the response carrying that id
+//with no response within RequestTimeout the same message is sent again, to the next of destinations,
+//waiting longer before each retry. on a redirect it is sent to the key's new owner instead
+func (c *Client) request(ctx context.Context, destinations []string, build func(identifier string) utilities.Message) (utilities.Message, error) {
+	c.mutex.Lock()
+	if c.closed {
+		c.mutex.Unlock()
+		return nil, ErrClosed
+	}
+	c.sequence++
+	identifier := utilities.RequestID(c.id, c.sequence)
+	responses := make(chan utilities.Message, 1)
+	c.waiting[identifier] = responses
+	c.mutex.Unlock()
+	defer func() {
+		c.mutex.Lock()
+		delete(c.waiting, identifier)
+		c.mutex.Unlock()
+	}()
+
+	message := build(identifier)
+	backoff := time.Second
+	attempt := 0
+	for redirects := 0; redirects <= maxRedirects; {
+		destination := destinations[attempt%len(destinations)]
+		err := c.pool.Send(message, destination)
+		if err == nil {
+			timer := time.NewTimer(c.config.RequestTimeout)
+			select {
+			case response, open := <-responses:
+				timer.Stop()
+				if !open {
+					return nil, ErrClosed
+				}
+				if rejection, rejected := response.(utilities.ErrorReply); rejected {
+					return nil, fmt.Errorf("%w: %v", ErrRejected, rejection)
+				}
+				redirect, redirected := response.(utilities.Redirect)
+				if !redirected {
+					return response, nil
+				}
+				c.mutex.Lock()
+				c.redirects[redirect.Prefix] = redirect.NewPrimary
+				c.mutex.Unlock()
+				message = c.redirected(message, redirect.NewPrimary)
+				destinations = []string{redirect.NewPrimary}
+				attempt = 0
+				redirects++
+				continue
+			case <-ctx.Done():
+				timer.Stop()
+				return nil, ctx.Err()
+			case <-timer.C:
+				err = ErrTimeout
+			}
+		}
+
+		attempt++
+		if attempt == c.config.MaxAttempts {
+			return nil, fmt.Errorf("%w after %d attempts, last one to %s: %v", ErrTimeout, attempt, destination, err)
+		}
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		backoff *= 2
+	}
+	return nil, errors.New("followed " + strconv.Itoa(maxRedirects) + " redirects without reaching the key's owner")
+}
+
+//message with its dependency replaced by the token of the group whose primary is newPrimary, to send it on after a redirect
+//the old token is a write index of the cluster the key moved away from, the new owner's replicas could wait forever for it
+func (c *Client) redirected(message utilities.Message, newPrimary string) utilities.Message {
+	switch request := message.(type) {
+	case utilities.GetRequest:
+		//a get without a token asked for none
+		if request.Dependency != 0 {
+			request.Dependency = c.sessionToken(newPrimary)
+		}
+		return request
+	case utilities.SetRequest:
+		request.Dependency = c.writeToken(newPrimary)
+		return request
+	case utilities.IncrRequest:
+		request.Dependency = c.writeToken(newPrimary)
+		return request
+	}
+	return message
+}
+
+//called by the pool with every message a worker sends, hands responses to the request waiting for them
+//every response carries the id of the request it answers
+//except replicas-update messages, which change the replicas reads go to
+func (c *Client) deliver(message utilities.Message, sender string) {
+	_, identifier := utilities.ReplyTarget(message)
+	//the lock is held while sending so Close can't close the channel in between, the send never blocks
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if update, isUpdate := message.(utilities.ReplicasUpdate); isUpdate {
+		for i := range c.groups {
+			if c.groups[i].Primary == update.Primary {
+				c.groups[i].Replicas = update.Replicas
+			}
+		}
+		return
+	}
+	responses, exists := c.waiting[identifier]
+	if !exists {
+		return
+	}
+	//a late response to an earlier attempt may already be there, one is all the request needs
+	select {
+	case responses <- message:
+	default:
+	}
+}
+
+//group owning key, or just the other cluster's primary if the key's prefix was migrated there
+func (c *Client) groupFor(key string) utilities.Group {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for prefix, newPrimary := range c.redirects {
+		if strings.HasPrefix(key, prefix) {
+			return utilities.Group{Primary: newPrimary}
+		}
+	}
+	return c.groups[c.ring.Lookup(key)]
+}
+
+//in eventual and causal mode every get carries the highest write index this client has seen from the group
+//whose primary is primary, a replica of that group can catch up to it
+//a token covering other groups' writes would have every replica of this group look behind, and pass every read on to the primary
+func (c *Client) sessionToken(primary string) int {
+	if c.config.Consistency != "eventual" && c.config.Consistency != "causal" {
+		return 0
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.dependencies[primary]
+}
+
+//in causal mode writes carry the session token too, so replicas apply them after the writes they depend on
+func (c *Client) writeToken(primary string) int {
+	if c.config.Consistency != "causal" {
+		return 0
+	}
+	return c.sessionToken(primary)
+}
+
+//raises the token of the group whose primary is primary to the write index in a response
+func (c *Client) observe(primary string, index int) {
+	c.mutex.Lock()
+	if index > c.dependencies[primary] {
+		c.dependencies[primary] = index
+	}
+	c.mutex.Unlock()
+}
+
+func valid(x string) bool {
+	return x != "" && !strings.ContainsAny(x, " \t\r\n")
+}
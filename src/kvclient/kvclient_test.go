@@ -0,0 +1,98 @@
+package kvclient
+
+import (
+	"DistKV/src/utilities"
+	"context"
+	"errors"
+	"testing"
+)
+
+//client for a cluster nobody is listening for, requests that would reach it fail
+func testClient(t *testing.T, consistency string) *Client {
+	groups := []utilities.Group{
+		{Primary: "localhost:1", Replicas: []string{"localhost:2"}},
+		{Primary: "localhost:3", Replicas: []string{"localhost:4"}},
+	}
+	c, err := New(Config{Groups: groups, Consistency: consistency, MaxAttempts: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func TestInvalidRequests(t *testing.T) {
+	c := testClient(t, "sequential")
+	ctx := context.Background()
+	if err := c.Set(ctx, "x", "NULL"); !errors.Is(err, ErrInvalid) {
+		t.Errorf("Set of NULL returned %v, want ErrInvalid", err)
+	}
+	if err := c.Set(ctx, "x", "two words"); !errors.Is(err, ErrInvalid) {
+		t.Errorf("Set of a value with a space returned %v, want ErrInvalid", err)
+	}
+	if err := c.Delete(ctx, ""); !errors.Is(err, ErrInvalid) {
+		t.Errorf("Delete of an empty key returned %v, want ErrInvalid", err)
+	}
+}
+
+//a write index seen from one group raises only that group's token
+func TestSessionTokenPerGroup(t *testing.T) {
+	c := testClient(t, "causal")
+	c.observe("localhost:1", 7)
+	c.observe("localhost:1", 3)
+	if got := c.sessionToken("localhost:1"); got != 7 {
+		t.Errorf("token of the first group is %d, want 7", got)
+	}
+	if got := c.sessionToken("localhost:3"); got != 0 {
+		t.Errorf("token of the second group is %d, want 0", got)
+	}
+
+	eventual := testClient(t, "eventual")
+	eventual.observe("localhost:1", 7)
+	if eventual.sessionToken("localhost:1") != 7 || eventual.writeToken("localhost:1") != 0 {
+		t.Errorf("in eventual mode only reads should carry the token")
+	}
+	sequential := testClient(t, "sequential")
+	sequential.observe("localhost:1", 7)
+	if sequential.sessionToken("localhost:1") != 0 {
+		t.Errorf("in sequential mode no request should carry a token")
+	}
+}
+
+//a request sent on after a redirect carries the new owner's token, not the one of the cluster the key moved away from
+func TestRedirectReplacesToken(t *testing.T) {
+	c := testClient(t, "causal")
+	c.observe("localhost:1", 7)
+	c.observe("localhost:5", 2)
+	set := c.redirected(utilities.SetRequest{Key: "x", Dependency: 7}, "localhost:5").(utilities.SetRequest)
+	if set.Dependency != 2 {
+		t.Errorf("redirected set depends on %d, want 2", set.Dependency)
+	}
+	get := c.redirected(utilities.GetRequest{Key: "x", Dependency: 7}, "localhost:6").(utilities.GetRequest)
+	if get.Dependency != 0 {
+		t.Errorf("redirected get to a cluster never seen depends on %d, want 0", get.Dependency)
+	}
+}
+
+//a replicas-update from a group's primary replaces that group's replicas and no other group's
+func TestReplicasUpdate(t *testing.T) {
+	c := testClient(t, "sequential")
+	c.deliver(utilities.ReplicasUpdate{Primary: "localhost:3", Replicas: []string{"localhost:5", "localhost:6"}}, "localhost:3")
+	if replicas := c.groups[1].Replicas; len(replicas) != 2 || replicas[0] != "localhost:5" || replicas[1] != "localhost:6" {
+		t.Errorf("second group's replicas are %v after the update", replicas)
+	}
+	if replicas := c.groups[0].Replicas; len(replicas) != 1 || replicas[0] != "localhost:2" {
+		t.Errorf("first group's replicas changed to %v", replicas)
+	}
+}
+
+func TestMultiwriterRequests(t *testing.T) {
+	c := testClient(t, "multiwriter")
+	if _, err := c.Incr(context.Background(), "x"); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("Incr in multiwriter mode returned %v, want ErrUnsupported", err)
+	}
+	sequential := testClient(t, "sequential")
+	if _, err := sequential.GetSiblings(context.Background(), "x"); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("GetSiblings outside multiwriter mode returned %v, want ErrUnsupported", err)
+	}
+}
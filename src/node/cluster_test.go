@@ -0,0 +1,266 @@
+package node
+
+import (
+	"DistKV/src/kvclient"
+	"DistKV/src/utilities"
+	"context"
+	"errors"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+//starts a primary and replicas of one group in this process, listening on unix sockets in a temporary directory
+//they are stopped when the test ends
+func startCluster(t *testing.T, consistency string, replicas int) (*Node, []*Node) {
+	dir := t.TempDir()
+	primaryAddress := "unix:" + filepath.Join(dir, "primary.sock")
+	var replicaNodes []*Node
+	var replicaAddresses []string
+	for i := 0; i < replicas; i++ {
+		address := "unix:" + filepath.Join(dir, "replica"+strconv.Itoa(i)+".sock")
+		replica := New(Config{Address: address, Role: "replica", Consistency: consistency, Primary: primaryAddress})
+		if err := replica.Start(); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(replica.Stop)
+		replicaNodes = append(replicaNodes, replica)
+		replicaAddresses = append(replicaAddresses, address)
+	}
+	primary := New(Config{Address: primaryAddress, Role: "primary", Consistency: consistency, Replicas: replicaAddresses})
+	if err := primary.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(primary.Stop)
+	return primary, replicaNodes
+}
+
+//a write acknowledged by the primary can be read back from the replica, through kvclient
+func TestClusterSetGet(t *testing.T) {
+	if testing.Short() {
+		t.Skip("replication is delayed by 5 seconds")
+	}
+	primary, replicas := startCluster(t, "sequential", 1)
+	c, err := kvclient.New(kvclient.Config{Primary: primary.Address(), Replicas: []string{replicas[0].Address()}, Consistency: "sequential"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if _, err := c.Get(ctx, "x"); !errors.Is(err, kvclient.ErrNotFound) {
+		t.Fatalf("get of a key that was never set returned %v, want ErrNotFound", err)
+	}
+	if err := c.Set(ctx, "x", "1"); err != nil {
+		t.Fatalf("set: %v", err)
+	}
+	//sequential writes are only acknowledged once every replica has them, so the replica must have it now
+	if value, err := c.GetWithOptions(ctx, "x", kvclient.ReadOptions{Consistency: "eventual"}); err != nil || value != "1" {
+		t.Errorf("get returned %q, %v, want 1", value, err)
+	}
+	if value, err := c.GetWithOptions(ctx, "x", kvclient.ReadOptions{Consistency: "strong"}); err != nil || value != "1" {
+		t.Errorf("get from the primary returned %q, %v, want 1", value, err)
+	}
+}
+
+//handlers blocked waiting on acks, a read index or replicated writes return once the node stops
+func TestStopReleasesWaits(t *testing.T) {
+	dir := t.TempDir()
+	//nobody listens at the primary's address, so nothing the replica waits for ever comes
+	n := New(Config{Address: "unix:" + filepath.Join(dir, "replica.sock"), Role: "replica", Consistency: "linearizable", Primary: "unix:" + filepath.Join(dir, "primary.sock")})
+	if err := n.Start(); err != nil {
+		t.Fatal(err)
+	}
+	waits := map[string]func() bool{
+		"waitForAcks":      func() bool { return n.waitForAcks("x", 1, nil) },
+		"waitForIndex":     func() bool { return n.waitForIndex(10, nil) },
+		"waitForReadIndex": n.waitForReadIndex,
+	}
+	results := make(chan string, len(waits))
+	for name, wait := range waits {
+		name, wait := name, wait
+		go func() {
+			if wait() {
+				t.Errorf("%s returned true", name)
+			}
+			results <- name
+		}()
+	}
+	time.Sleep(100 * time.Millisecond)
+	n.Stop()
+	for i := 0; i < len(waits); i++ {
+		select {
+		case <-results:
+		case <-time.After(2 * time.Second):
+			t.Fatalf("only %d of %d waits returned after Stop", i, len(waits))
+		}
+	}
+}
+
+//a write migrated from another cluster only replaces the key's value if the source stamped it later
+func TestMigratedWritesKeepTheNewestValue(t *testing.T) {
+	primary, _ := startCluster(t, "eventual", 0)
+	nobody := "unix:" + filepath.Join(t.TempDir(), "nobody.sock")
+	now := primary.clock.Now()
+	older := utilities.Timestamp{Wall: now.Wall + 1000}
+	newer := utilities.Timestamp{Wall: now.Wall + 2000}
+
+	primary.primarySet(utilities.SetRequest{Key: "x", Value: "new", ReplyTo: nobody, ID: "migrate-1", Stamp: newer})
+	primary.primarySet(utilities.SetRequest{Key: "x", Value: "old", ReplyTo: nobody, ID: "migrate-1", Stamp: older})
+	if primary.store["x"] != "new" {
+		t.Errorf("an older migrated write overwrote a newer one, value is %s", primary.store["x"])
+	}
+	//writes made at the new owner afterwards are stamped later than anything migrated to it
+	primary.primarySet(utilities.SetRequest{Key: "x", Value: "local", ReplyTo: nobody, ID: "c#1"})
+	if primary.store["x"] != "local" || !newer.Before(primary.stamps["x"]) {
+		t.Errorf("a write after the migration didn't win, value is %s stamped %s", primary.store["x"], primary.stamps["x"])
+	}
+}
+
+//a migration the new owner refuses a write of ends without a cutover, one it acks every write of cuts over
+func TestMigrateAbortsOnRefusal(t *testing.T) {
+	source, _ := startCluster(t, "eventual", 0)
+	destination, _ := startCluster(t, "eventual", 0)
+	nobody := "unix:" + filepath.Join(t.TempDir(), "nobody.sock")
+	for _, key := range []string{"p1", "p2", "q1"} {
+		source.primarySet(utilities.SetRequest{Key: key, Value: "1", ReplyTo: nobody, ID: "c#" + key})
+	}
+
+	destination.txnMutex.Lock()
+	destination.locks["p1"] = "tx"
+	destination.txnMutex.Unlock()
+	finished := make(chan struct{})
+	go func() {
+		source.migrate(utilities.MigrateRequest{Prefix: "p", NewPrimary: destination.Address(), ReplyTo: nobody, ID: "admin#1"})
+		close(finished)
+	}()
+	select {
+	case <-finished:
+	case <-time.After(10 * time.Second):
+		t.Fatal("migration didn't end after the new owner refused a write")
+	}
+	if _, newPrimary := source.movedTo("p1"); newPrimary != "" || source.store["p1"] != "1" {
+		t.Fatalf("an aborted migration cut over")
+	}
+
+	destination.txnMutex.Lock()
+	delete(destination.locks, "p1")
+	destination.txnMutex.Unlock()
+	source.migrate(utilities.MigrateRequest{Prefix: "p", NewPrimary: destination.Address(), ReplyTo: nobody, ID: "admin#2"})
+	if _, newPrimary := source.movedTo("p1"); newPrimary != destination.Address() {
+		t.Errorf("migration didn't cut over")
+	}
+	destination.storeMutex.RLock()
+	defer destination.storeMutex.RUnlock()
+	if destination.store["p1"] != "1" || destination.store["p2"] != "1" || destination.store["q1"] != "" {
+		t.Errorf("new owner has %v", destination.store)
+	}
+}
+
+//a prefix with a key locked by a prepared transaction isn't migrated, the commit would land after the cutover
+func TestMigrateRefusesLockedKeys(t *testing.T) {
+	source, _ := startCluster(t, "eventual", 0)
+	destination, _ := startCluster(t, "eventual", 0)
+	nobody := "unix:" + filepath.Join(t.TempDir(), "nobody.sock")
+	source.primarySet(utilities.SetRequest{Key: "p1", Value: "1", ReplyTo: nobody, ID: "c#1"})
+	source.prepare(utilities.Prepare{ID: "tx", ReplyTo: nobody, Keys: []string{"p1"}, Values: []string{"2"}})
+
+	source.migrate(utilities.MigrateRequest{Prefix: "p", NewPrimary: destination.Address(), ReplyTo: nobody, ID: "admin#1"})
+	if _, newPrimary := source.movedTo("p1"); newPrimary != "" {
+		t.Fatalf("a prefix with a locked key was migrated")
+	}
+
+	source.decide(utilities.Decision{Decision: "commit", ID: "tx", ReplyTo: nobody})
+	source.migrate(utilities.MigrateRequest{Prefix: "p", NewPrimary: destination.Address(), ReplyTo: nobody, ID: "admin#2"})
+	destination.storeMutex.RLock()
+	defer destination.storeMutex.RUnlock()
+	if destination.store["p1"] != "2" {
+		t.Errorf("the committed value didn't reach the new owner, it has %q", destination.store["p1"])
+	}
+}
+
+//a causal replica applies a write whose dependency isn't below its own index instead of waiting for it forever
+func TestReplicaClampsDependency(t *testing.T) {
+	_, replicas := startCluster(t, "causal", 1)
+	applied := make(chan struct{})
+	go func() {
+		replicas[0].replicaSet(utilities.ReplicateRequest{Key: "x", Value: "1", Index: 1, Dependency: 1, Stamp: utilities.Timestamp{Wall: 1}})
+		close(applied)
+	}()
+	select {
+	case <-applied:
+	case <-time.After(2 * time.Second):
+		t.Fatal("replica waited for a write's own index")
+	}
+	replicas[0].storeMutex.RLock()
+	defer replicas[0].storeMutex.RUnlock()
+	if replicas[0].store["x"] != "1" {
+		t.Errorf("replica has %q", replicas[0].store["x"])
+	}
+}
+
+//kvclient asks every primary for replica set changes, and reads and writes a multiwriter cluster
+func TestClientWatchesReplicas(t *testing.T) {
+	primary, _ := startCluster(t, "multiwriter", 0)
+	c, err := kvclient.New(kvclient.Config{Primary: primary.Address(), Consistency: "multiwriter", Listen: "unix:" + filepath.Join(t.TempDir(), "client.sock")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := c.Set(ctx, "x", "1"); err != nil {
+		t.Fatalf("set: %v", err)
+	}
+	if value, err := c.Get(ctx, "x"); err != nil || value != "1" {
+		t.Errorf("get returned %q, %v, want 1", value, err)
+	}
+	//the client sends watch-replicas on its own goroutine, it may come in after the requests
+	var watchers []string
+	for deadline := time.Now().Add(2 * time.Second); len(watchers) == 0 && time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		primary.storeMutex.RLock()
+		watchers = primary.watchers
+		primary.storeMutex.RUnlock()
+	}
+	if len(watchers) != 1 {
+		t.Fatalf("primary has watchers %v, want the client", watchers)
+	}
+
+	//a watcher that went away is dropped at the next change
+	c.Close()
+	primary.storeMutex.Lock()
+	primary.broadcastReplicas()
+	watchers = primary.watchers
+	primary.storeMutex.Unlock()
+	if len(watchers) != 0 {
+		t.Errorf("primary still has watchers %v after the client closed", watchers)
+	}
+}
+
+//a node started without a role can be initialized while its background loops and the producer are running
+//only fails under -race, as a data race on the fields initialize writes
+func TestInitializeWhileRunning(t *testing.T) {
+	dir := t.TempDir()
+	address := "unix:" + filepath.Join(dir, "worker.sock")
+	nobody := "unix:" + filepath.Join(dir, "nobody.sock")
+	n := New(Config{Address: address})
+	if err := n.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(n.Stop)
+	//the heartbeat loop looks at the role every heartbeatInterval
+	time.Sleep(heartbeatInterval + 100*time.Millisecond)
+	n.producer(utilities.Initialize{Role: "primary", Consistency: "eventual", Self: address, Primary: address, Replicas: []string{nobody}}, nobody)
+	for i := 0; i < 10; i++ {
+		n.producer(utilities.GetRequest{Key: "x", ReplyTo: nobody, ID: "c#" + strconv.Itoa(i)}, nobody)
+	}
+	time.Sleep(heartbeatInterval + 100*time.Millisecond)
+	n.roleMutex.RLock()
+	defer n.roleMutex.RUnlock()
+	if n.role != "primary" {
+		t.Errorf("role is %q after initialize", n.role)
+	}
+}
@@ -0,0 +1,1972 @@
+package node
+
+import (
+	"DistKV/src/utilities"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//number of a client's most recent writes kept in completed, older ones are forgotten
+const dedupeWindow = 1000
+
+//most clients completed keeps writes of, the one that wrote least recently is forgotten to make room
+//a client process gets a new id every time it starts, so without this the map grows with every client that ever wrote
+const maxDedupeClients = 1024
+
+//one version of a key in multiwriter mode
+//dot is the write that made it, a single entry clock of the worker that took it and that worker's write count
+//context is the clock the client sent with the write, every version it covers was overwritten by this one
+type sibling struct {
+	value   string
+	dot     utilities.VectorClock
+	context utilities.VectorClock
+}
+
+//key prefix being copied to another cluster (see migrate)
+//identifier is what the new owner's acks are counted under, sent is how many writes it has been sent so far
+type migration struct {
+	destination string
+	identifier  string
+	sent        int
+}
+
+//writes a participant has promised to make if the coordinator decides to commit (see prepare)
+type intent struct {
+	coordinator string
+	keys        []string
+	values      []string
+	prepared    time.Time
+}
+
+//how long a transaction can stay prepared before the participant asks its coordinator for the outcome
+const inDoubtTimeout = 10 * time.Second
+
+//how often the primary tells its replicas the index of its latest write, replicas measure their lag from these
+const heartbeatInterval = time.Second
+
+//a heartbeat a replica hasn't caught up with yet: the primary's index and when it arrived
+type heartbeatMark struct {
+	index    int
+	received time.Time
+}
+
+//most heartbeats a replica keeps while it is behind, the oldest is dropped to make room
+//that only makes the replica look staler than it is, so a bounded read goes to the primary instead
+const maxPendingHeartbeats = 64
+
+//how long a replica's lease grant lasts, measured from when the primary asked for it
+const leaseDuration = 10 * time.Second
+
+//leases are treated as expired this long early, to allow for clock drift between primary and replicas
+const leaseMargin = time.Second
+
+//how long a primary adding a replica waits for it to ack the copy of the store, writes wait behind it meanwhile
+const bootstrapTimeout = 10 * time.Second
+
+//how long a migration waits for the new owner to ack another write before giving up
+//it is measured from the last ack, since every write may take several replication delays at the new owner
+const migrateTimeout = 60 * time.Second
+
+//how long a write is held back before it is passed on to each replica, to make stale reads easy to see
+//the primary passes a write to its replicas one after the other, so the k-th replica gets it k delays after the primary applied it
+const replicationDelay = 5 * time.Second
+
+//how long a linearizable replica waits for the primary's read index before passing a get on to the primary
+//the wait for the writes up to it also allows a replication delay per replica up to this one, see readIndexWait
+const readIndexTimeout = 10 * time.Second
+
+//how a Node listens, and optionally what it starts as
+//a Node started without a role waits for the tester's initialize message, like the worker binary does
+type Config struct {
+	//address other processes reach the node at, which is also the address to listen on unless Listen is set
+	//a port of 0 picks a free port, see Address
+	Address string
+	//address to listen on when it isn't Address: ":9000" for every interface, one IP ("10.0.0.5:9000", "[::1]:9000"),
+	//or a unix socket ("unix:/tmp/worker.sock"). Address is then only announced to peers, the listener's address if empty
+	Listen string
+	//"primary" or "replica", or empty to wait for an initialize message
+	Role string
+	//consistency mode of the cluster, see consistency in Node
+	Consistency string
+	//primary of the node's group, a primary's own address if empty
+	Primary string
+	//replicas of the node's group
+	Replicas []string
+	//every replica group of the cluster, for a cluster with several
+	Groups []utilities.Group
+	//on a primary: clients to tell when the replica set changes
+	Clients []string
+	//tester coordinating a test run, if there is one
+	Tester string
+	//where every message the node receives is printed, nothing is printed if nil
+	Log io.Writer
+	//address to listen on for redis clients (see resp.go), in any form Listen takes. no redis listener if empty
+	RESPAddress string
+	//directory for files that have to outlive the process, named after the node's address
+	//holds the log of prepared transactions (see intentLogPath), which are only kept in memory if empty
+	DataDir string
+}
+
+//one worker, primary or replica, with all of its state
+//several can run in the same process, each with its own listener and connections
+type Node struct {
+	config   Config
+	listener net.Listener
+	pool     *utilities.Pool
+	log      io.Writer
+	//closed by Stop, background loops return once it is
+	stopped  chan struct{}
+	stopOnce sync.Once
+	//nil unless Config has a RESPAddress
+	resp *respServer
+
+	//hashtable mapping keys to values
+	store map[string]string
+
+	//index of the write that set each key's value in store, protected by storeMutex
+	//clients track these to know which writes a read depends on
+	versions map[string]int
+
+	//hybrid logical clock timestamp of the write that set each key's value in store, protected by storeMutex
+	//a replica keeps whichever write for a key has the latest timestamp (last writer wins)
+	stamps map[string]utilities.Timestamp
+
+	//hybrid logical clock stamping this worker's writes and log lines, has its own mutex
+	clock utilities.Clock
+
+	//on the primary: result sent back for each write already applied, by client id and then sequence number
+	//a retried primary-set gets the original result back instead of being applied again. protected by storeMutex
+	completed map[string]map[int]utilities.Message
+	//for each client in completed, how many writes had been recorded when its last one was, protected by storeMutex
+	lastCompleted map[string]int
+	//writes recorded in completed so far, protected by storeMutex
+	recordedWrites int
+
+	//in multiwriter mode: versions of each key that no other version has overwritten, protected by storeMutex
+	//there is more than one when writes at different workers were concurrent
+	siblings map[string][]sibling
+
+	//in multiwriter mode: number of writes this worker has taken, its own entry in the vector clocks, protected by storeMutex
+	writeCount int
+
+	//convergent values (counters, sets and registers) that any worker can update in any mode, protected by storeMutex
+	//kept apart from store, a key is either a plain value or a crdt
+	crdts map[string]utilities.CRDT
+
+	//role of the worker: can be "primary" or "replica"
+	role string
+
+	//consistency guarantee of distributed KV store, can be "eventual", "causal", "sequential", "linearizable", "chain", or "multiwriter"
+	//chain is chain replication: writes go down the replicas list in order and reads are served by the last replica (the tail)
+	//causal writes don't block, but replicas hold back writes and reads until what they depend on has been applied
+	//multiwriter lets every worker take writes, concurrent writes to a key are kept side by side as siblings
+	consistency string
+
+	//list of strings of format "ip:port" for the various replicas in the system
+	//on the primary this can change at runtime (see addReplica), it is written holding both storeMutex and replicasMutex
+	//so it can be read holding either one
+	replicas []string
+
+	//mutex to protect access to replicas for code that can't wait behind a write holding storeMutex (leases)
+	replicasMutex sync.RWMutex
+
+	//every replica group of the cluster, in init.txt order, nil if this node's group is the only one
+	//only used to route requests taken on behalf of clients (see serveRESP)
+	groups []utilities.Group
+
+	//list of strings of format "ip:port" for the clients, only known by the primary
+	//used to tell clients when the replica set changes
+	clients []string
+	//on a primary: clients the tester doesn't know of that asked to be told as well (see watchReplicas), protected by storeMutex
+	watchers []string
+
+	//string of the ip:port where ip is current proc's WAN address, port is the port it is listening on
+	self string
+
+	//string of the ip:port that the tester is listening on
+	tester string
+
+	//string of the ip:port that the primary is listening on (can be equal to self)
+	primary string
+
+	//protects role, consistency, self, tester, primary, clients and groups while initialize writes them
+	//handlers the consumer starts don't need it, initialize runs on the consumer's goroutine before they start
+	//the background loops, the producer and redis connections read the fields holding it
+	roleMutex sync.RWMutex
+
+	//queue of incoming messages, filled by the producers and emptied by the consumer
+	messages *utilities.MessageQueue
+
+	//prefixes currently being migrated, only used on the primary, protected by storeMutex
+	migrations map[string]*migration
+
+	//prepared transactions on this primary, by transaction id, protected by txnMutex
+	intents map[string]*intent
+
+	//keys locked by a prepared transaction, mapped to the transaction id, protected by txnMutex
+	locks map[string]string
+
+	//mutex to protect access to intents and locks
+	txnMutex sync.RWMutex
+
+	//prefixes that have been cut over to another cluster, mapped to that cluster's primary
+	//requests for these keys get a redirect reply, protected by movedMutex
+	moved map[string]string
+
+	//mutex to protect access to moved
+	movedMutex sync.RWMutex
+
+	//versions of the values in applied
+	appliedVersions map[string]int
+
+	//copy of the primary's store that can be read while a write holds storeMutex
+	//reads under a valid lease are answered from here, since store is locked for as long as a write is replicating
+	//the primary orders every write and writes never fail once applied, so a read may see a write that is still replicating
+	applied map[string]string
+
+	//mutex to protect access to applied and lastIndex
+	appliedMutex sync.RWMutex
+
+	//on the primary: index of the last write it applied, each write gets the next index
+	//a replica that has applied up to the primary's lastIndex has seen every write the primary could have shown a reader
+	lastIndex int
+
+	//on a replica: every write from the primary up to this index has been applied, protected by storeMutex
+	appliedIndex int
+
+	//on a replica: indexes above appliedIndex that were applied early, before a write below them arrived
+	//protected by storeMutex
+	appliedAhead map[int]bool
+
+	//on a replica: answers to read-index requests, by request id, protected by responseMutex
+	//-1 until the answer arrives, requests nobody waits for anymore aren't in it
+	readIndexes map[string]int
+
+	//on a replica: highest write index the primary is known to have, from heartbeats and replicated writes
+	//protected by storeMutex
+	primaryIndex int
+
+	//on a replica being added: number of bootstrap-sets it has applied, protected by storeMutex
+	bootstrapped int
+
+	//notified whenever bootstrapped goes up
+	bootstrapChanged utilities.Signal
+
+	//on a replica: heartbeats whose index is above appliedIndex, oldest first, protected by storeMutex
+	//indexes and arrival times both go up along it, so there is at most one per index (see heartbeat)
+	pendingHeartbeats []heartbeatMark
+
+	//on a replica: arrival time of the latest heartbeat this replica has caught up with, protected by storeMutex
+	//the replica had every write the primary had at that moment, so nothing it serves is older than this
+	freshAsOf time.Time
+
+	//on the primary: when each replica's grant runs out, on the primary's clock
+	leaseGrants map[string]time.Time
+
+	//on a replica: primary it has granted a lease to, and when the grant runs out on the replica's clock
+	leaseHolder string
+	leaseExpiry time.Time
+
+	//mutex to protect access to leaseGrants, leaseHolder and leaseExpiry
+	leaseMutex sync.RWMutex
+
+	//map to store responses
+	//each write's hybrid logical clock timestamp is used as unique identifier for its acks
+	responses map[string]int
+
+	//running migrations by identifier, and why the new owner refused one of their writes ("" until it does)
+	//protected by responseMutex
+	migrationFailures map[string]string
+
+	//mutex to protect access to store
+	storeMutex sync.RWMutex
+
+	//mutex to protect access to responses
+	responseMutex sync.RWMutex
+
+	//notified whenever responses or readIndexes change
+	responsesChanged utilities.Signal
+
+	//notified whenever appliedIndex moves
+	appliedChanged utilities.Signal
+
+	//number of messages rejected as malformed or of an unknown kind, see Rejected
+	rejected int
+
+	//mutex to protect access to rejected
+	rejectedMutex sync.Mutex
+}
+
+//node that isn't listening yet, call Start to run it
+func New(config Config) *Node {
+	n := &Node{
+		config:            config,
+		log:               config.Log,
+		stopped:           make(chan struct{}),
+		messages:          utilities.NewMessageQueue(),
+		responses:         map[string]int{},
+		migrationFailures: map[string]string{},
+		store:             map[string]string{},
+		versions:          map[string]int{},
+		stamps:            map[string]utilities.Timestamp{},
+		completed:         map[string]map[int]utilities.Message{},
+		lastCompleted:     map[string]int{},
+		siblings:          map[string][]sibling{},
+		crdts:             map[string]utilities.CRDT{},
+		appliedAhead:      map[int]bool{},
+		appliedVersions:   map[string]int{},
+		migrations:        map[string]*migration{},
+		moved:             map[string]string{},
+		applied:           map[string]string{},
+		readIndexes:       map[string]int{},
+		leaseGrants:       map[string]time.Time{},
+		intents:           map[string]*intent{},
+		locks:             map[string]string{},
+	}
+	if n.log == nil {
+		n.log = io.Discard
+	}
+	return n
+}
+
+//starts listening and handling messages, returns once the node is ready for them
+func (n *Node) Start() error {
+	listen := n.config.Listen
+	if listen == "" {
+		listen = n.config.Address
+	}
+	listener, err := utilities.Listen(listen)
+	if err != nil {
+		return err
+	}
+	n.listener = listener
+	if n.config.Listen == "" || n.config.Address == "" {
+		n.config.Address = utilities.ListenerAddress(listener)
+	}
+	if n.config.RESPAddress != "" {
+		respListener, err := utilities.Listen(n.config.RESPAddress)
+		if err != nil {
+			listener.Close()
+			return err
+		}
+		n.resp = &respServer{listener: respListener, expiries: map[string]*time.Timer{}}
+	}
+
+	if n.config.Role != "" {
+		n.role = n.config.Role
+		n.consistency = n.config.Consistency
+		n.replicas = n.config.Replicas
+		n.clients = n.config.Clients
+		n.groups = n.config.Groups
+		n.self = n.config.Address
+		n.tester = n.config.Tester
+		n.primary = n.config.Primary
+		if n.primary == "" && n.role == "primary" {
+			n.primary = n.self
+		}
+		n.recoverIntents()
+	}
+
+	//every message to and from the node goes over the pool's connections, which hand incoming ones to producer
+	n.pool = utilities.NewPool(n.config.Address, utilities.PoolSize, n.producer)
+	go n.pool.Serve(listener)
+	go n.consumer()
+	go n.resolveInDoubt()
+	go n.renewLease()
+	go n.sendHeartbeats()
+	if n.resp != nil {
+		go n.serveRESP()
+	}
+	return nil
+}
+
+//stops listening and handling messages, and closes the node's connections
+//handlers that are already running finish, but can't send anything. a stopped node can't be started again
+func (n *Node) Stop() {
+	n.stopOnce.Do(func() {
+		close(n.stopped)
+		n.listener.Close()
+		n.pool.Close()
+		n.messages.Close()
+		if n.resp != nil {
+			n.stopRESP()
+		}
+	})
+}
+
+//channel that is closed once the node has stopped, either by Stop or by an exit message
+func (n *Node) Done() <-chan struct{} {
+	return n.stopped
+}
+
+//address other processes reach the node at, with the port filled in if Config.Address asked for a free one
+func (n *Node) Address() string {
+	return n.config.Address
+}
+
+//waits for d, returns false instead if the node is stopped first
+func (n *Node) wait(d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-n.stopped:
+		return false
+	}
+}
+
+//puts a message from one of the pooled connections into the queue
+//a message that isn't valid is rejected here, so handlers only ever get messages with every field they read
+func (n *Node) producer(message utilities.Message, sender string) {
+	if err := utilities.ValidateMessage(message); err != nil {
+		n.reject(message, sender, err)
+		return
+	}
+
+	//linearizable reads skip the queue entirely while the primary holds a lease
+	//so do eventual reads, which only reach the primary when a replica is behind a client's session
+	//answered on their own goroutine, the producer runs on the pool's read loop and must not send
+	n.roleMutex.RLock()
+	role, consistency := n.role, n.consistency
+	n.roleMutex.RUnlock()
+	if get, isGet := message.(utilities.GetRequest); isGet && role == "primary" && (consistency == "eventual" || consistency == "linearizable" && n.leaseValid()) {
+		go n.leaseGet(get)
+		return
+	}
+
+	fmt.Fprint(n.log, "message received @ "+n.clock.Now().String()+": "+message.String()+"\n")
+	//acknowledgements from replicas have highest priority to prevent deadlock
+	switch message.(type) {
+	case utilities.ReplicateAck, utilities.SetResult, utilities.BootstrapAck, utilities.SetConflict, utilities.Redirect, utilities.ErrorReply:
+		n.messages.Push(message, utilities.PriorityAck)
+	default:
+		n.messages.Push(message, utilities.PriorityRequest)
+	}
+}
+
+//drops a message that couldn't be handled, counts it and answers with an error message
+//the answer goes to the peer that sent the message, or to the reply address in the message if the sender isn't known
+//output to sender: ErrorReply
+func (n *Node) reject(message utilities.Message, sender string, err error) {
+	n.rejectedMutex.Lock()
+	n.rejected++
+	count := n.rejected
+	n.rejectedMutex.Unlock()
+	fmt.Fprint(n.log, "message rejected ("+strconv.Itoa(count)+" so far): "+strconv.Quote(message.String())+": "+err.Error()+"\n")
+
+	//errors are never answered, two workers would otherwise keep answering each other
+	if _, isError := message.(utilities.ErrorReply); isError {
+		return
+	}
+	replyTo, identifier := utilities.ReplyTarget(message)
+	if sender != "" {
+		replyTo = sender
+	}
+	if replyTo == "" {
+		return
+	}
+	n.roleMutex.RLock()
+	self := n.self
+	n.roleMutex.RUnlock()
+	if self == "" {
+		self = n.config.Address
+	}
+	//sent on its own goroutine, reject is called from the producer
+	go n.pool.Send(utilities.ErrorReply{Rejected: message.Kind(), Node: self, ID: identifier, Reason: err.Error()}, replyTo)
+}
+
+//number of messages the node has rejected since it started
+func (n *Node) Rejected() int {
+	n.rejectedMutex.Lock()
+	defer n.rejectedMutex.Unlock()
+	return n.rejected
+}
+
+//consume messages from queue, blocking while it is empty
+func (n *Node) consumer() {
+	for {
+		message := n.messages.Pop()
+		if message == nil {
+			//the node was stopped
+			return
+		}
+
+		switch m := message.(type) {
+		case utilities.Initialize:
+			n.initialize(m)
+		case utilities.ReplicateRequest:
+			go n.replicaSet(m)
+		case utilities.ChainSet:
+			go n.chainSet(m)
+		case utilities.BootstrapDone:
+			go n.bootstrapDone(m)
+		case utilities.Heartbeat:
+			go n.heartbeat(m)
+		case utilities.ReadIndexRequest:
+			go n.readIndex(m)
+		case utilities.ReadIndexResult:
+			go n.readIndexResult(m)
+		case utilities.SetRequest:
+			go n.primarySet(m)
+		case utilities.IncrRequest:
+			go n.primaryIncr(m)
+		case utilities.GetRequest:
+			go n.get(m)
+		case utilities.MultiSetRequest:
+			go n.multiSet(m)
+		case utilities.MultiReplicate:
+			go n.multiReplicate(m)
+		case utilities.CRDTUpdate:
+			go n.crdtUpdate(m)
+		case utilities.CRDTMerge:
+			go n.crdtMerge(m)
+		case utilities.CRDTGet:
+			go n.crdtGet(m)
+		case utilities.ReplicateAck:
+			go n.countAck(m.ID)
+		case utilities.SetResult:
+			//acks from another cluster's primary for keys being migrated, counted like replica acks
+			go n.countAck(m.ID)
+		case utilities.BootstrapAck:
+			go n.countAck(m.ID)
+		case utilities.Prepare:
+			go n.prepare(m)
+		case utilities.Decision:
+			go n.decide(m)
+		case utilities.LeaseRequest:
+			go n.leaseRequest(m)
+		case utilities.LeaseGrant:
+			go n.leaseGrant(m)
+		case utilities.MigrateRequest:
+			go n.migrate(m)
+		case utilities.MigrateCutover:
+			go n.migrateCutover(m)
+		case utilities.ReconfigureRequest:
+			if m.Operation == "add" {
+				go n.addReplica(m)
+			} else {
+				go n.removeReplica(m)
+			}
+		case utilities.BootstrapSet:
+			go n.bootstrapSet(m)
+		case utilities.ReplicasUpdate:
+			go n.replicasUpdate(m)
+		case utilities.WatchReplicas:
+			go n.watchReplicas(m)
+		case utilities.Exit:
+			//replicas added at runtime are unknown to the tester, so the primary passes exit along
+			if n.role == "primary" {
+				n.storeMutex.RLock()
+				for _, replica := range n.replicas {
+					n.pool.Send(utilities.Exit{Sender: n.self}, replica)
+				}
+				n.storeMutex.RUnlock()
+			}
+			fmt.Fprint(n.log, "Process completed.\n")
+			n.Stop()
+			return
+		case utilities.VersionMismatch:
+			//the pool already closed the connection, a peer running another version is only worth a warning
+			fmt.Fprint(n.log, "warning: "+m.String()+", this node speaks protocol version "+strconv.Itoa(utilities.ProtocolVersion)+"\n")
+		case utilities.SetConflict:
+			go n.migrationFailed(m.ID, m.Key+" is locked by a transaction at the new owner")
+		case utilities.Redirect:
+			go n.migrationFailed(m.ID, m.Key+" has moved on from the new owner to "+m.NewPrimary)
+		case utilities.ErrorReply:
+			//another worker couldn't handle something this one sent it
+			fmt.Fprint(n.log, "warning: "+m.Error()+"\n")
+			go n.migrationFailed(m.ID, m.Error())
+		default:
+			//answers meant for clients
+			n.reject(message, "", errors.New("workers don't take "+message.Kind()+" messages"))
+		}
+	}
+}
+
+/*
+The following functions are for different request types, each gets its message from the consumer
+(which will consume from the message queue), with every field already checked by the producer
+
+Then it will handle the message, this may depend on the consistency
+See the message types in utilities/protocol.go for what each field is
+
+*/
+
+//this will be sent from client to primary or replica
+//get value from map
+//in linearizable mode a replica first waits until it has applied everything up to the primary's read index
+//in causal mode a replica first waits until it has applied everything up to the client's dependency, or forwards the get to the primary if that takes too long
+//in eventual mode a replica that hasn't applied up to the client's session token forwards the get to the primary instead
+//a replica further behind than the client's staleness bound forwards the get to the primary as well
+//output to client: GetResult, or a Redirect if the key was migrated away
+func (n *Node) get(request utilities.GetRequest) {
+	if n.consistency == "multiwriter" {
+		n.multiGet(request)
+		return
+	}
+	//a replica that can't catch up with the read index in time passes the get on to the primary, which has every write
+	if n.role == "replica" && n.consistency == "linearizable" && !n.waitForReadIndex() {
+		n.pool.Send(request, n.primary)
+		return
+	}
+	//a replica that doesn't catch up with the client's dependency in time passes the get on to the primary as well
+	if n.role == "replica" && n.consistency == "causal" && !n.waitForDependency(request.Dependency) {
+		n.pool.Send(request, n.primary)
+		return
+	}
+	if n.role == "replica" && n.consistency == "eventual" {
+		n.storeMutex.RLock()
+		behind := n.appliedIndex < request.Dependency
+		n.storeMutex.RUnlock()
+		//the primary has every write, it answers the client directly
+		if behind {
+			n.pool.Send(request, n.primary)
+			return
+		}
+	}
+	if n.role == "replica" && n.consistency != "linearizable" && !n.withinBound(request.Options) {
+		n.pool.Send(request, n.primary)
+		return
+	}
+	n.storeMutex.Lock()
+	value, exists := n.store[request.Key]
+	version := n.versions[request.Key]
+	prefix, newPrimary := n.movedTo(request.Key)
+	n.storeMutex.Unlock()
+
+	if newPrimary != "" {
+		n.pool.Send(utilities.Redirect{Key: request.Key, NewPrimary: newPrimary, ID: request.ID, Prefix: prefix}, request.ReplyTo)
+		return
+	}
+
+	if !exists {
+		value = "NULL"
+	}
+	n.pool.Send(utilities.GetResult{Key: request.Key, Value: value, ID: request.ID, Version: version}, request.ReplyTo)
+}
+
+//this will be sent from client to primary
+//set value (from primary's perspective, will message the replicas)
+//will block waiting for OKs from N replicas
+//the dependency is the highest write index the client has seen, replicas won't apply the write before it in causal mode
+//output to client: SetResult, or a Redirect if the key was migrated away, or a SetConflict if it is locked by a transaction
+//a client identifier that is a request id ("__CLIENTID__#__SEQUENCE__") is applied at most once,
+//a retry of a write that was already applied gets the first result again
+func (n *Node) primarySet(request utilities.SetRequest) {
+	n.storeMutex.Lock()
+	defer n.storeMutex.Unlock()
+	if n.answerRetry(request.ID, request.ReplyTo) {
+		return
+	}
+	//writes passed on by a migration keep the source's stamp. the bulk copy and the writes streamed during it
+	//take turns at storeMutex in no particular order, so one older than the key's value is acked without being applied
+	if !request.Stamp.IsZero() {
+		n.clock.Update(request.Stamp)
+		if !n.stamps[request.Key].Before(request.Stamp) {
+			n.pool.Send(utilities.SetResult{Key: request.Key, Value: n.store[request.Key], ID: request.ID, Index: n.versions[request.Key]}, request.ReplyTo)
+			return
+		}
+	}
+	n.primaryWrite(request.Key, request.Value, request.ReplyTo, request.ID, request.Dependency, request.Stamp)
+}
+
+//this will be sent from client to primary
+//adds one to the key's value, a key without a value counts as 0. applied and replicated like a primary-set of the new value
+//output to client: same as primary-set, with the new value, or an IncrError if the value isn't an integer
+func (n *Node) primaryIncr(request utilities.IncrRequest) {
+	n.storeMutex.Lock()
+	defer n.storeMutex.Unlock()
+	if n.answerRetry(request.ID, request.ReplyTo) {
+		return
+	}
+	current, exists := n.store[request.Key]
+	if !exists || current == "NULL" {
+		current = "0"
+	}
+	i, err := strconv.Atoi(current)
+	if err != nil {
+		n.pool.Send(utilities.IncrError{Key: request.Key, Value: current, ID: request.ID}, request.ReplyTo)
+		return
+	}
+	n.primaryWrite(request.Key, strconv.Itoa(i+1), request.ReplyTo, request.ID, request.Dependency, utilities.Timestamp{})
+}
+
+//sends the original result again if clientIdentifier is a request id this primary already applied
+//returns true if it did, caller must hold storeMutex
+func (n *Node) answerRetry(clientIdentifier string, destination string) bool {
+	client, sequence, isRequest := utilities.ParseRequestID(clientIdentifier)
+	if result, done := n.completed[client][sequence]; isRequest && done {
+		n.pool.Send(result, destination)
+		return true
+	}
+	return false
+}
+
+//applies a client's write on the primary, replicates it and answers the client
+//unless the key was migrated away or is locked by a transaction, which the client is told instead
+//caller must hold storeMutex
+func (n *Node) primaryWrite(key string, value string, destination string, clientIdentifier string, dependency int, carried utilities.Timestamp) {
+	if prefix, newPrimary := n.movedTo(key); newPrimary != "" {
+		n.pool.Send(utilities.Redirect{Key: key, NewPrimary: newPrimary, ID: clientIdentifier, Prefix: prefix}, destination)
+		return
+	}
+
+	//keys locked by a prepared transaction are rejected rather than waited on, so writers can't deadlock with it
+	n.txnMutex.RLock()
+	_, locked := n.locks[key]
+	n.txnMutex.RUnlock()
+	if locked {
+		n.pool.Send(utilities.SetConflict{Key: key, Value: value, ID: clientIdentifier}, destination)
+		return
+	}
+	index, stamp := n.applyValue(key, value, carried)
+	//a write can't depend on itself or later writes, replicas would wait for it forever
+	if dependency >= index {
+		dependency = index - 1
+	}
+	n.store[key] = value
+	n.versions[key] = index
+	n.stamps[key] = stamp
+	result := utilities.SetResult{Key: key, Value: value, ID: clientIdentifier, Index: index}
+	if client, sequence, isRequest := utilities.ParseRequestID(clientIdentifier); isRequest {
+		n.recordCompleted(client, sequence, result)
+	}
+
+	if !n.blockingWrites() {
+		n.pool.Send(result, destination)
+	}
+
+	n.replicate(key, value, index, stamp, dependency)
+
+	if n.blockingWrites() {
+		n.pool.Send(result, destination)
+	}
+}
+
+//remembers the result of a client's write, and forgets the client's writes older than dedupeWindow
+//recorded before the write replicates, a retry waits on storeMutex until the first attempt has answered
+//caller must hold storeMutex
+func (n *Node) recordCompleted(client string, sequence int, result utilities.Message) {
+	if n.completed[client] == nil {
+		n.completed[client] = map[int]utilities.Message{}
+	}
+	n.completed[client][sequence] = result
+	n.recordedWrites++
+	n.lastCompleted[client] = n.recordedWrites
+	if len(n.completed) > maxDedupeClients {
+		n.forgetOldestClient()
+	}
+	for seq := range n.completed[client] {
+		if seq <= sequence-dedupeWindow {
+			delete(n.completed[client], seq)
+		}
+	}
+}
+
+//drops the writes of the client that wrote least recently from completed
+//a retry from it is applied again, which only happens if it retries after over maxDedupeClients other clients wrote
+//caller must hold storeMutex
+func (n *Node) forgetOldestClient() {
+	oldest := ""
+	for client, at := range n.lastCompleted {
+		if oldest == "" || at < n.lastCompleted[oldest] {
+			oldest = client
+		}
+	}
+	delete(n.completed, oldest)
+	delete(n.lastCompleted, oldest)
+}
+
+//pushes a write that is already in the primary's store out to the replicas
+//(and to the new owner, if the key's prefix is being migrated)
+//will block waiting for OKs from N replicas in sequential and linearizable mode
+//in chain mode the write is only sent to the first replica, and this blocks waiting for the tail's OK
+//index and stamp are the write's index and timestamp from applyValue, replicas record them as they apply the write
+//the stamp is unique to the write, so it also identifies the replicas' acks
+//dependency is the highest index the write depends on, in causal mode replicas apply it only after that index
+//caller must hold storeMutex
+func (n *Node) replicate(key string, value string, index int, stamp utilities.Timestamp, dependency int) {
+
+	//keys being migrated are streamed to the new owner as they are written
+	for prefix, m := range n.migrations {
+		if strings.HasPrefix(key, prefix) {
+			n.pool.Send(utilities.SetRequest{Key: key, Value: value, ReplyTo: n.self, ID: m.identifier, Stamp: stamp}, m.destination)
+			m.sent++
+		}
+	}
+
+	identifier := stamp.String()
+
+	if n.consistency == "chain" {
+		if len(n.replicas) > 0 {
+			time.Sleep(replicationDelay)
+			n.pool.Send(utilities.ChainSet{Key: key, Value: value, Stamp: stamp, Index: index}, n.replicas[0])
+			n.waitForAcks(identifier, 1, nil)
+		}
+		return
+	}
+
+	for _, destination := range n.replicas {
+
+		//massive delay added to make it easier to test eventual consistency
+		time.Sleep(replicationDelay)
+
+		n.pool.Send(utilities.ReplicateRequest{Key: key, Value: value, Stamp: stamp, Index: index, Dependency: dependency}, destination)
+
+	}
+
+	//block waiting for OKs from replicas
+	if n.blockingWrites() {
+		n.waitForAcks(identifier, len(n.replicas), nil)
+	}
+}
+
+//blocks until needed replica acks with this identifier have come in, or until timeout fires or the node stops
+//returns whether the acks came in, a nil timeout waits for as long as it takes
+func (n *Node) waitForAcks(identifier string, needed int, timeout <-chan time.Time) bool {
+	//how will we count replies? -> use the write's timestamp as unique identifier
+	for {
+		changed := n.responsesChanged.Changed()
+		n.responseMutex.RLock()
+		count := n.responses[identifier]
+		n.responseMutex.RUnlock()
+
+		if count >= needed {
+			return true
+		}
+		select {
+		case <-changed:
+		case <-timeout:
+			return false
+		case <-n.stopped:
+			return false
+		}
+	}
+}
+
+//whether the primary only answers a set once the write has reached the replicas
+func (n *Node) blockingWrites() bool {
+	c := utilities.TrimString(n.consistency)
+	return c == "sequential" || c == "linearizable" || c == "chain"
+}
+
+//this will be sent from primary to replica
+//set value (from replica's perspective, will respond to primary with an OK)
+//in causal mode the write is held back until every write up to its dependency has been applied
+//the write's timestamp identifies the ack
+func (n *Node) replicaSet(request utilities.ReplicateRequest) {
+	n.clock.Update(request.Stamp)
+	//the primary clamps the dependency below the write's own index, this doesn't trust it to
+	dependency := request.Dependency
+	if dependency >= request.Index {
+		dependency = request.Index - 1
+	}
+	if n.consistency == "causal" && !n.waitForIndex(dependency, nil) {
+		return
+	}
+	n.storeMutex.Lock()
+	n.applyReplicated(request.Key, request.Value, request.Index, request.Stamp)
+	n.pool.Send(utilities.ReplicateAck{Key: request.Key, Value: request.Value, ID: request.Stamp.String()}, n.primary)
+	n.storeMutex.Unlock()
+
+}
+
+//this will be sent from the primary (head) or the previous replica down the chain, in chain mode
+//set value and pass it on to the next replica, the last replica (tail) acks to the primary instead
+func (n *Node) chainSet(request utilities.ChainSet) {
+	n.storeMutex.Lock()
+	n.clock.Update(request.Stamp)
+	n.applyReplicated(request.Key, request.Value, request.Index, request.Stamp)
+	idx := n.indexOf(n.replicas, n.self)
+	next := ""
+	if idx != -1 && idx+1 < len(n.replicas) {
+		next = n.replicas[idx+1]
+	}
+	n.storeMutex.Unlock()
+
+	if next == "" {
+		n.pool.Send(utilities.ReplicateAck{Key: request.Key, Value: request.Value, ID: request.Stamp.String()}, n.primary)
+		return
+	}
+	//same delay as the primary adds per replica
+	time.Sleep(replicationDelay)
+	n.pool.Send(request, next)
+}
+
+//applies a write from the primary on a replica, caller must hold storeMutex
+//a write with an older timestamp than the key's current value (it arrived late) or one that raced with a cutover
+//isn't kept, but its index still counts as applied
+func (n *Node) applyReplicated(key string, value string, index int, stamp utilities.Timestamp) {
+	if _, newPrimary := n.movedTo(key); newPrimary == "" && n.stamps[key].Before(stamp) {
+		n.store[key] = value
+		n.versions[key] = index
+		n.stamps[key] = stamp
+	}
+	if index > n.primaryIndex {
+		n.primaryIndex = index
+	}
+	if index > n.appliedIndex {
+		n.appliedAhead[index] = true
+	}
+	n.advanceAppliedIndex()
+}
+
+//moves appliedIndex past writes that were applied early and are now contiguous, caller must hold storeMutex
+//then marks every heartbeat at or below appliedIndex as caught up with
+func (n *Node) advanceAppliedIndex() {
+	for n.appliedAhead[n.appliedIndex+1] {
+		delete(n.appliedAhead, n.appliedIndex+1)
+		n.appliedIndex++
+	}
+	n.appliedChanged.Notify()
+	for len(n.pendingHeartbeats) > 0 && n.pendingHeartbeats[0].index <= n.appliedIndex {
+		n.freshAsOf = n.pendingHeartbeats[0].received
+		n.pendingHeartbeats = n.pendingHeartbeats[1:]
+	}
+}
+
+//runs forever on every worker, only does anything on a primary
+//output to replicas: Heartbeat with the primary's latest write
+func (n *Node) sendHeartbeats() {
+	for n.wait(heartbeatInterval) {
+		n.roleMutex.RLock()
+		role := n.role
+		n.roleMutex.RUnlock()
+		if role != "primary" {
+			continue
+		}
+		n.appliedMutex.RLock()
+		index := n.lastIndex
+		n.appliedMutex.RUnlock()
+		n.replicasMutex.RLock()
+		for _, replica := range n.replicas {
+			n.pool.Send(utilities.Heartbeat{Index: index}, replica)
+		}
+		n.replicasMutex.RUnlock()
+	}
+}
+
+//this will be sent from primary to replica every heartbeatInterval
+func (n *Node) heartbeat(message utilities.Heartbeat) {
+	index := message.Index
+	n.storeMutex.Lock()
+	if index > n.primaryIndex {
+		n.primaryIndex = index
+	}
+	//a heartbeat with an index as high or higher that arrived earlier says less than this one, it is replaced
+	kept := len(n.pendingHeartbeats)
+	for kept > 0 && n.pendingHeartbeats[kept-1].index >= index {
+		kept--
+	}
+	n.pendingHeartbeats = append(n.pendingHeartbeats[:kept], heartbeatMark{index: index, received: time.Now()})
+	if len(n.pendingHeartbeats) > maxPendingHeartbeats {
+		n.pendingHeartbeats = n.pendingHeartbeats[1:]
+	}
+	n.advanceAppliedIndex()
+	n.storeMutex.Unlock()
+}
+
+//checks a get's optional staleness bound against this replica's lag, true if there is no bound
+//"maxstaleness=__MILLIS__" is within bound if the replica had all of the primary's writes that recently
+//"maxversions=__N__" is within bound if the replica is missing at most N of the writes it knows the primary has
+func (n *Node) withinBound(fields []string) bool {
+	n.storeMutex.RLock()
+	defer n.storeMutex.RUnlock()
+	for _, field := range fields {
+		option := strings.Split(field, "=")
+		if len(option) != 2 {
+			continue
+		}
+		limit, err := strconv.Atoi(option[1])
+		if err != nil {
+			continue
+		}
+		switch option[0] {
+		case "maxstaleness":
+			if n.freshAsOf.IsZero() || time.Since(n.freshAsOf) > time.Duration(limit)*time.Millisecond {
+				return false
+			}
+		case "maxversions":
+			if n.primaryIndex-n.appliedIndex > limit {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+//blocks until this replica has applied every write up to index, or until timeout fires or the node stops
+//returns whether it caught up, a nil timeout waits for as long as it takes
+func (n *Node) waitForIndex(index int, timeout <-chan time.Time) bool {
+	for {
+		changed := n.appliedChanged.Changed()
+		n.storeMutex.RLock()
+		caughtUp := n.appliedIndex >= index
+		n.storeMutex.RUnlock()
+
+		if caughtUp {
+			return true
+		}
+		select {
+		case <-changed:
+		case <-timeout:
+			return false
+		case <-n.stopped:
+			return false
+		}
+	}
+}
+
+//blocks until this replica has applied every write up to index, allowing as long as for a read index
+//returns false if it didn't in time, or the node stopped first
+func (n *Node) waitForDependency(index int) bool {
+	timer := time.NewTimer(n.readIndexWait())
+	defer timer.Stop()
+	return n.waitForIndex(index, timer.C)
+}
+
+//function to handle acknowledgements from pushing new values to replicas
+//these are ReplicateAcks, or SetResults from the new owner of a prefix being migrated
+func (n *Node) countAck(identifier string) {
+	n.responseMutex.Lock()
+	n.responses[identifier]++
+	n.responseMutex.Unlock()
+	n.responsesChanged.Notify()
+}
+
+//get in multiwriter mode, answered from this worker's siblings
+//context is the merge of the siblings' clocks, a set sent with it overwrites every sibling returned here
+//output to client: Siblings, with no values if the key isn't set
+func (n *Node) multiGet(request utilities.GetRequest) {
+	n.storeMutex.RLock()
+	result := utilities.Siblings{Key: request.Key, Context: utilities.VectorClock{}, ID: request.ID}
+	for _, version := range n.siblings[request.Key] {
+		result.Context = result.Context.Merge(version.context).Merge(version.dot)
+		result.Values = append(result.Values, version.value)
+	}
+	n.storeMutex.RUnlock()
+	n.pool.Send(result, request.ReplyTo)
+}
+
+//this will be sent from client to any worker in multiwriter mode
+//the write gets a new dot from this worker, and overwrites the siblings covered by the client's context,
+//it is concurrent with any the client hadn't seen, even ones taken by this worker
+//each request id is applied once per worker, like primary-set on the primary
+//output to client: MultiSetResult
+func (n *Node) multiSet(request utilities.MultiSetRequest) {
+	n.storeMutex.Lock()
+	client, sequence, isRequest := utilities.ParseRequestID(request.ID)
+	if result, done := n.completed[client][sequence]; isRequest && done {
+		n.storeMutex.Unlock()
+		n.pool.Send(result, request.ReplyTo)
+		return
+	}
+	n.writeCount++
+	dot := utilities.VectorClock{n.self: n.writeCount}
+	n.addSibling(request.Key, sibling{value: request.Value, dot: dot, context: request.Context})
+	result := utilities.MultiSetResult{Key: request.Key, Value: request.Value, ID: request.ID, Clock: request.Context.Merge(dot)}
+	if isRequest {
+		n.recordCompleted(client, sequence, result)
+	}
+	peers := n.groupPeers()
+	n.storeMutex.Unlock()
+
+	n.pool.Send(result, request.ReplyTo)
+
+	//same delay as eventual replication, so writes at different workers have time to be concurrent
+	time.Sleep(replicationDelay)
+	for _, peer := range peers {
+		n.pool.Send(utilities.MultiReplicate{Key: request.Key, Value: request.Value, Dot: dot, Context: request.Context}, peer)
+	}
+}
+
+//this will be sent from the worker that took a multiwriter write to every other worker in the group
+func (n *Node) multiReplicate(message utilities.MultiReplicate) {
+	version := sibling{value: message.Value, dot: message.Dot, context: message.Context}
+	n.storeMutex.Lock()
+	n.addSibling(message.Key, version)
+	n.storeMutex.Unlock()
+}
+
+//every other worker of this worker's group, caller must hold storeMutex
+func (n *Node) groupPeers() []string {
+	peers := []string{}
+	if n.primary != n.self {
+		peers = append(peers, n.primary)
+	}
+	for _, replica := range n.replicas {
+		if replica != n.self {
+			peers = append(peers, replica)
+		}
+	}
+	return peers
+}
+
+//this will be sent from client to any worker
+//applies an update to a convergent value, answers with the new value, then sends the update to the rest of the group
+//ops: "ginc" (grow-only counter), "inc" and "dec" (counter), "sadd" and "srem" (set), "lwwset" (last-writer-wins register)
+//the first update of a key picks its type, updates of another type get WRONGTYPE back
+//each request id is applied once per worker, like primary-set on the primary
+//output to client: CRDTResult
+//output to the rest of the group: CRDTMerge with the update's delta
+func (n *Node) crdtUpdate(request utilities.CRDTUpdate) {
+	key := request.Key
+	op := request.Op
+	argument := request.Argument
+	destination := request.ReplyTo
+	clientIdentifier := request.ID
+
+	kind := map[string]string{"ginc": "gcounter", "inc": "pncounter", "dec": "pncounter", "sadd": "orset", "srem": "orset", "lwwset": "lww"}[op]
+	amount, err := strconv.Atoi(argument)
+	if kind == "" || (kind == "gcounter" || kind == "pncounter") && (err != nil || amount < 0) {
+		n.pool.Send(utilities.CRDTResult{Key: key, Value: "BADREQUEST", ID: clientIdentifier}, destination)
+		return
+	}
+
+	n.storeMutex.Lock()
+	client, sequence, isRequest := utilities.ParseRequestID(clientIdentifier)
+	if result, done := n.completed[client][sequence]; isRequest && done {
+		n.storeMutex.Unlock()
+		n.pool.Send(result, destination)
+		return
+	}
+	c, exists := n.crdts[key]
+	if !exists {
+		c, _ = utilities.NewCRDT(kind)
+		n.crdts[key] = c
+	}
+	if utilities.CRDTKind(c) != kind {
+		n.storeMutex.Unlock()
+		n.pool.Send(utilities.CRDTResult{Key: key, Value: "WRONGTYPE", ID: clientIdentifier}, destination)
+		return
+	}
+
+	var delta utilities.CRDT
+	switch op {
+	case "ginc":
+		delta = c.(utilities.GCounter).Increment(n.self, amount)
+	case "inc":
+		delta = c.(*utilities.PNCounter).Increment(n.self, amount)
+	case "dec":
+		delta = c.(*utilities.PNCounter).Increment(n.self, -amount)
+	case "sadd":
+		delta = c.(*utilities.ORSet).Add(argument, n.self+"@"+n.clock.Now().String())
+	case "srem":
+		delta = c.(*utilities.ORSet).Remove(argument)
+	case "lwwset":
+		delta = c.(*utilities.LWWRegister).Set(argument, n.clock.Now(), n.self)
+	}
+	result := utilities.CRDTResult{Key: key, Value: c.Value(), ID: clientIdentifier}
+	if isRequest {
+		n.recordCompleted(client, sequence, result)
+	}
+	peers := n.groupPeers()
+	n.storeMutex.Unlock()
+
+	n.pool.Send(result, destination)
+
+	//same delay as eventual replication
+	time.Sleep(replicationDelay)
+	for _, peer := range peers {
+		n.pool.Send(utilities.CRDTMerge{Key: key, Delta: delta}, peer)
+	}
+}
+
+//this will be sent from the worker that took a crdt update to every other worker in the group
+//merges are commutative and idempotent, so they can be applied in any order and more than once
+func (n *Node) crdtMerge(message utilities.CRDTMerge) {
+	delta := message.Delta
+	kind := utilities.CRDTKind(delta)
+	n.storeMutex.Lock()
+	if lww, isRegister := delta.(*utilities.LWWRegister); isRegister {
+		n.clock.Update(lww.Stamp)
+	}
+	c, exists := n.crdts[message.Key]
+	if !exists {
+		c, _ = utilities.NewCRDT(kind)
+		n.crdts[message.Key] = c
+	}
+	//a worker that saw a different type first keeps it, the client that used the key both ways got WRONGTYPE somewhere
+	if utilities.CRDTKind(c) == kind {
+		c.Merge(delta)
+	}
+	n.storeMutex.Unlock()
+}
+
+//this will be sent from client to any worker
+//output to client: CRDTResult, value is NULL if the key was never updated
+func (n *Node) crdtGet(request utilities.CRDTGet) {
+	value := "NULL"
+	n.storeMutex.RLock()
+	if c, exists := n.crdts[request.Key]; exists {
+		value = c.Value()
+	}
+	n.storeMutex.RUnlock()
+	n.pool.Send(utilities.CRDTResult{Key: request.Key, Value: value, ID: request.ID}, request.ReplyTo)
+}
+
+//adds a version of key unless it is already there or a sibling overwrote it, and drops the siblings it overwrites
+//caller must hold storeMutex
+func (n *Node) addSibling(key string, version sibling) {
+	var kept []sibling
+	for _, existing := range n.siblings[key] {
+		if existing.dot.String() == version.dot.String() || existing.context.Descends(version.dot) {
+			return
+		}
+		if !version.context.Descends(existing.dot) {
+			kept = append(kept, existing)
+		}
+	}
+	n.siblings[key] = append(kept, version)
+}
+
+//this will be sent from a transaction coordinator (client) to the primary of every group the transaction writes to
+//first phase of two-phase commit: votes yes and locks the keys if none of them are locked or migrated away, else votes no
+//output to coordinator: PrepareResult where vote is "yes" or "no"
+func (n *Node) prepare(request utilities.Prepare) {
+	txid := request.ID
+	coordinator := request.ReplyTo
+	t := &intent{coordinator: coordinator, keys: request.Keys, values: request.Values, prepared: time.Now()}
+
+	//storeMutex orders this against primarySet's lock check
+	n.storeMutex.Lock()
+	n.txnMutex.Lock()
+	vote := "yes"
+	for _, key := range t.keys {
+		if holder, locked := n.locks[key]; locked && holder != txid {
+			vote = "no"
+		}
+		if _, newPrimary := n.movedTo(key); newPrimary != "" {
+			vote = "no"
+		}
+		//a key being migrated would be committed here after the cutover dropped it
+		for prefix := range n.migrations {
+			if strings.HasPrefix(key, prefix) {
+				vote = "no"
+			}
+		}
+	}
+	//the promise has to survive a restart before the coordinator hears it, a participant that can't keep it votes no
+	if vote == "yes" {
+		if err := n.logIntent(prepareRecord(txid, t)); err != nil {
+			fmt.Fprint(n.log, "warning: couldn't log transaction "+txid+", voting no: "+err.Error()+"\n")
+			vote = "no"
+		}
+	}
+	if vote == "yes" {
+		n.intents[txid] = t
+		for _, key := range t.keys {
+			n.locks[key] = txid
+		}
+	}
+	n.txnMutex.Unlock()
+	n.storeMutex.Unlock()
+
+	n.pool.Send(utilities.PrepareResult{Participant: n.self, Vote: vote, ID: txid}, coordinator)
+}
+
+//path of this participant's intent log, one line per record:
+//"prepare TXID COORDINATOR KEY1 VALUE1 KEY2 VALUE2 ..." before it votes yes, "done TXID" once the transaction is decided
+//"" if the node has no DataDir
+func (n *Node) intentLogPath() string {
+	if n.config.DataDir == "" || n.self == "" {
+		return ""
+	}
+	return filepath.Join(n.config.DataDir, utilities.RemoveColon(n.self)+".intents")
+}
+
+//record of a prepared transaction in the intent log
+func prepareRecord(txid string, t *intent) string {
+	record := "prepare " + txid + " " + t.coordinator
+	for i, key := range t.keys {
+		record += " " + key + " " + t.values[i]
+	}
+	return record
+}
+
+//appends a record to the intent log and waits for it to reach the disk
+func (n *Node) logIntent(record string) error {
+	path := n.intentLogPath()
+	if path == "" {
+		return nil
+	}
+	out, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	if _, err := out.WriteString(record + "\n"); err != nil {
+		return err
+	}
+	return out.Sync()
+}
+
+//reads the intent log left by an earlier run of this participant
+//transactions that were prepared but never decided are prepared again, keys locked and all,
+//and counted as in doubt straight away so resolveInDoubt asks their coordinators for the outcome
+//the log is then rewritten with only those transactions in it
+func (n *Node) recoverIntents() {
+	path := n.intentLogPath()
+	if path == "" {
+		return
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return
+	}
+	recovered := map[string]*intent{}
+	var order []string
+	for _, line := range strings.Split(string(data), "\n") {
+		spl := strings.Split(line, " ")
+		switch {
+		case len(spl) == 2 && spl[0] == "done":
+			delete(recovered, spl[1])
+		case len(spl) >= 5 && len(spl)%2 == 1 && spl[0] == "prepare":
+			t := &intent{coordinator: spl[2]}
+			for i := 3; i+1 < len(spl); i += 2 {
+				t.keys = append(t.keys, spl[i])
+				t.values = append(t.values, spl[i+1])
+			}
+			if _, seen := recovered[spl[1]]; !seen {
+				order = append(order, spl[1])
+			}
+			recovered[spl[1]] = t
+		}
+	}
+
+	n.txnMutex.Lock()
+	defer n.txnMutex.Unlock()
+	compacted := ""
+	for _, txid := range order {
+		t, open := recovered[txid]
+		if !open {
+			continue
+		}
+		n.intents[txid] = t
+		for _, key := range t.keys {
+			n.locks[key] = txid
+		}
+		compacted += prepareRecord(txid, t) + "\n"
+		fmt.Fprint(n.log, "recovered prepared transaction "+txid+" from "+t.coordinator+"\n")
+	}
+	//written next to the log and renamed over it, so a crash while compacting leaves one of the two whole
+	if err := os.WriteFile(path+".tmp", []byte(compacted), 0644); err == nil {
+		os.Rename(path+".tmp", path)
+	}
+}
+
+//this will be sent from the coordinator to every participant once it has decided, and again during recovery
+//second phase of two-phase commit: on commit the prepared writes are applied and replicated, then the locks are released
+//deciding a transaction that isn't prepared here (already decided, or voted no) only sends the ack again
+//output to coordinator: DecisionResult
+func (n *Node) decide(message utilities.Decision) {
+	decision := message.Decision
+	txid := message.ID
+	coordinator := message.ReplyTo
+
+	n.storeMutex.Lock()
+	n.txnMutex.Lock()
+	t, exists := n.intents[txid]
+	delete(n.intents, txid)
+	n.txnMutex.Unlock()
+
+	if exists && decision == "commit" {
+		//every key becomes visible to lease reads at once, before any of them replicate
+		indexes := make([]int, len(t.keys))
+		keyStamps := make([]utilities.Timestamp, len(t.keys))
+		for i, key := range t.keys {
+			indexes[i], keyStamps[i] = n.applyValue(key, t.values[i], utilities.Timestamp{})
+			n.store[key] = t.values[i]
+			n.versions[key] = indexes[i]
+			n.stamps[key] = keyStamps[i]
+		}
+		for i, key := range t.keys {
+			n.replicate(key, t.values[i], indexes[i], keyStamps[i], 0)
+		}
+	}
+
+	if exists {
+		n.txnMutex.Lock()
+		for _, key := range t.keys {
+			delete(n.locks, key)
+		}
+		n.txnMutex.Unlock()
+		//if this record is lost the transaction is recovered after a restart, and the coordinator decides it again
+		if err := n.logIntent("done " + txid); err != nil {
+			fmt.Fprint(n.log, "warning: couldn't log the end of transaction "+txid+": "+err.Error()+"\n")
+		}
+	}
+	n.storeMutex.Unlock()
+
+	n.pool.Send(utilities.DecisionResult{Participant: n.self, Decision: decision, ID: txid}, coordinator)
+}
+
+//runs forever on every worker, asks the coordinator of any transaction prepared for too long what it decided
+//the coordinator answers with a commit or abort message, if it is down the transaction stays prepared until it comes back
+//output to coordinator: TxnStatus
+func (n *Node) resolveInDoubt() {
+	for n.wait(inDoubtTimeout / 2) {
+		n.roleMutex.RLock()
+		self := n.self
+		n.roleMutex.RUnlock()
+		n.txnMutex.RLock()
+		for txid, t := range n.intents {
+			if time.Since(t.prepared) > inDoubtTimeout {
+				n.pool.Send(utilities.TxnStatus{ID: txid, ReplyTo: self}, t.coordinator)
+			}
+		}
+		n.txnMutex.RUnlock()
+	}
+}
+
+//makes a write the primary just applied visible to lease reads, and gives it the next index and a timestamp
+func (n *Node) applyValue(key string, value string, carried utilities.Timestamp) (int, utilities.Timestamp) {
+	n.appliedMutex.Lock()
+	n.lastIndex++
+	index := n.lastIndex
+	//stamped under appliedMutex so timestamps are in the same order as indexes
+	//a write migrated from another cluster keeps the stamp it had there, which the clock has already been updated with
+	stamp := carried
+	if stamp.IsZero() {
+		stamp = n.clock.Now()
+	}
+	n.applied[key] = value
+	n.appliedVersions[key] = index
+	n.appliedMutex.Unlock()
+	return index, stamp
+}
+
+//this will be sent from replica to primary in linearizable mode, before the replica answers a get
+//the read index is the index of the last write the primary applied, including writes still replicating
+//output to replica: ReadIndexResult
+func (n *Node) readIndex(request utilities.ReadIndexRequest) {
+	n.appliedMutex.RLock()
+	index := n.lastIndex
+	n.appliedMutex.RUnlock()
+	n.pool.Send(utilities.ReadIndexResult{Primary: n.self, Index: index, ID: request.ID}, request.ReplyTo)
+}
+
+//an answer that comes in after the replica gave up on it is dropped
+func (n *Node) readIndexResult(result utilities.ReadIndexResult) {
+	n.responseMutex.Lock()
+	if _, waiting := n.readIndexes[result.ID]; waiting {
+		n.readIndexes[result.ID] = result.Index
+	}
+	n.responseMutex.Unlock()
+	n.responsesChanged.Notify()
+}
+
+//how long this replica waits for a read index and the writes up to it, or for the writes up to a causal get's dependency
+//the writes come a replication delay apart down the replica list, so later replicas wait longer for them
+func (n *Node) readIndexWait() time.Duration {
+	n.replicasMutex.RLock()
+	defer n.replicasMutex.RUnlock()
+	position := len(n.replicas)
+	for i, replica := range n.replicas {
+		if replica == n.self {
+			position = i + 1
+			break
+		}
+	}
+	return readIndexTimeout + time.Duration(position)*replicationDelay
+}
+
+//asks the primary for its read index, then blocks until this replica has applied every write up to it
+//after that the replica's store is at least as new as anything a linearizable reader could have seen
+//returns false if that didn't happen within readIndexWait, or the node stopped first
+func (n *Node) waitForReadIndex() bool {
+	timer := time.NewTimer(n.readIndexWait())
+	defer timer.Stop()
+	requestID := "read-" + fmt.Sprint(time.Now().UnixNano())
+	n.responseMutex.Lock()
+	n.readIndexes[requestID] = -1
+	n.responseMutex.Unlock()
+	n.pool.Send(utilities.ReadIndexRequest{ReplyTo: n.self, ID: requestID}, n.primary)
+
+	index := -1
+	for index < 0 {
+		changed := n.responsesChanged.Changed()
+		n.responseMutex.Lock()
+		index = n.readIndexes[requestID]
+		if index >= 0 {
+			delete(n.readIndexes, requestID)
+		}
+		n.responseMutex.Unlock()
+
+		if index < 0 {
+			select {
+			case <-changed:
+				continue
+			case <-timer.C:
+			case <-n.stopped:
+			}
+			n.responseMutex.Lock()
+			delete(n.readIndexes, requestID)
+			n.responseMutex.Unlock()
+			return false
+		}
+	}
+	return n.waitForIndex(index, timer.C)
+}
+
+//runs forever on every worker, only does anything on a linearizable primary
+//asks every replica for a lease every quarter of leaseDuration, so the lease is renewed well before it runs out
+//output to replicas: LeaseRequest where start is the primary's clock in millis when it asked
+func (n *Node) renewLease() {
+	for n.wait(leaseDuration / 4) {
+		n.roleMutex.RLock()
+		role, consistency, self := n.role, n.consistency, n.self
+		n.roleMutex.RUnlock()
+		if role != "primary" || consistency != "linearizable" {
+			continue
+		}
+		request := utilities.LeaseRequest{Start: utilities.GetTimeInMillis(), ReplyTo: self}
+		n.replicasMutex.RLock()
+		for _, replica := range n.replicas {
+			n.pool.Send(request, replica)
+		}
+		n.replicasMutex.RUnlock()
+	}
+}
+
+//this will be sent from primary to replica
+//the replica promises not to grant a lease to any other primary for leaseDuration, unless it already promised one
+//output to primary: LeaseGrant with the start it asked at
+func (n *Node) leaseRequest(request utilities.LeaseRequest) {
+	requester := request.ReplyTo
+
+	n.leaseMutex.Lock()
+	granted := n.leaseHolder == "" || n.leaseHolder == requester || time.Now().After(n.leaseExpiry)
+	if granted {
+		n.leaseHolder = requester
+		n.leaseExpiry = time.Now().Add(leaseDuration)
+	}
+	n.leaseMutex.Unlock()
+
+	if granted {
+		n.pool.Send(utilities.LeaseGrant{Replica: n.self, Start: request.Start}, requester)
+	}
+}
+
+//this will be sent from replica to primary
+//the grant is counted from when the primary asked, which is never later than when the replica started its own timer
+func (n *Node) leaseGrant(grant utilities.LeaseGrant) {
+	expiry := time.Unix(0, grant.Start*int64(time.Millisecond)).Add(leaseDuration)
+
+	n.leaseMutex.Lock()
+	if expiry.After(n.leaseGrants[grant.Replica]) {
+		n.leaseGrants[grant.Replica] = expiry
+	}
+	n.leaseMutex.Unlock()
+}
+
+//whether every current replica's grant is still good (minus leaseMargin)
+//a replica added since the last renewal hasn't granted anything yet, so the lease lapses until it does
+func (n *Node) leaseValid() bool {
+	deadline := time.Now().Add(leaseMargin)
+	n.leaseMutex.RLock()
+	defer n.leaseMutex.RUnlock()
+	n.replicasMutex.RLock()
+	defer n.replicasMutex.RUnlock()
+	for _, replica := range n.replicas {
+		if n.leaseGrants[replica].Before(deadline) {
+			return false
+		}
+	}
+	return true
+}
+
+//linearizable read answered by a primary holding a valid lease, or eventual read forwarded by a replica, straight from the producer
+//reads the applied copy of the store, so it never waits behind a write that is still replicating
+//output to client: same as get
+func (n *Node) leaseGet(request utilities.GetRequest) {
+	if prefix, newPrimary := n.movedTo(request.Key); newPrimary != "" {
+		n.pool.Send(utilities.Redirect{Key: request.Key, NewPrimary: newPrimary, ID: request.ID, Prefix: prefix}, request.ReplyTo)
+		return
+	}
+
+	n.appliedMutex.RLock()
+	value, exists := n.applied[request.Key]
+	version := n.appliedVersions[request.Key]
+	n.appliedMutex.RUnlock()
+
+	if !exists {
+		value = "NULL"
+	}
+	n.pool.Send(utilities.GetResult{Key: request.Key, Value: value, ID: request.ID, Version: version}, request.ReplyTo)
+}
+
+//this will be sent from an admin client to primary
+//moves every key starting with prefix to the cluster whose primary is newPrimary, while still serving traffic:
+//1. copy the matching keys to the new owner as ordinary primary-sets, and start streaming new writes to it
+//2. wait until the new owner has acked every write it was sent
+//3. cut over: drop the keys, tell replicas, and answer further requests for the prefix with a redirect
+//if the new owner refuses a write, or stops acking them for migrateTimeout, the migration is aborted before the cutover
+//a prefix with a key locked by a transaction isn't migrated at all
+//output to client: MigrateResult, or an ErrorReply if the migration was aborted
+func (n *Node) migrate(request utilities.MigrateRequest) {
+	prefix := request.Prefix
+	newPrimary := request.NewPrimary
+
+	m := &migration{destination: newPrimary, identifier: "migrate-" + fmt.Sprint(time.Now().UnixNano())}
+
+	//a key locked by a prepared transaction could be committed after the cutover, to a store that no longer serves it
+	//so the migration is refused, and prepares for the prefix are voted down until it is over
+	n.storeMutex.Lock()
+	n.txnMutex.RLock()
+	locked := ""
+	for key, txid := range n.locks {
+		if strings.HasPrefix(key, prefix) {
+			locked = key + " is locked by transaction " + txid
+		}
+	}
+	n.txnMutex.RUnlock()
+	if locked != "" {
+		n.storeMutex.Unlock()
+		reason := "can't migrate " + prefix + " now, " + locked
+		n.pool.Send(utilities.ErrorReply{Rejected: request.Kind(), Node: n.self, ID: request.ID, Reason: reason}, request.ReplyTo)
+		return
+	}
+
+	//every copy carries the key's stamp, so the new owner keeps the newest value whatever order the copies
+	//and the writes streamed after them are applied in
+	for key, value := range n.store {
+		if strings.HasPrefix(key, prefix) {
+			n.pool.Send(utilities.SetRequest{Key: key, Value: value, ReplyTo: n.self, ID: m.identifier, Stamp: n.stamps[key]}, newPrimary)
+			m.sent++
+		}
+	}
+	n.migrations[prefix] = m
+	n.storeMutex.Unlock()
+	n.responseMutex.Lock()
+	n.migrationFailures[m.identifier] = ""
+	n.responseMutex.Unlock()
+
+	lastAck := time.Now()
+	acked := 0
+	failure := ""
+	for failure == "" {
+		changed := n.responsesChanged.Changed()
+		n.storeMutex.Lock()
+		n.responseMutex.RLock()
+		count := n.responses[m.identifier]
+		failure = n.migrationFailures[m.identifier]
+		n.responseMutex.RUnlock()
+
+		//checked under storeMutex so no write can be streamed between the check and the cutover
+		if failure == "" && count >= m.sent {
+			delete(n.migrations, prefix)
+			n.movedMutex.Lock()
+			n.moved[prefix] = newPrimary
+			n.movedMutex.Unlock()
+			n.appliedMutex.Lock()
+			for key := range n.store {
+				if strings.HasPrefix(key, prefix) {
+					delete(n.store, key)
+					delete(n.versions, key)
+					delete(n.stamps, key)
+					delete(n.applied, key)
+				}
+			}
+			n.appliedMutex.Unlock()
+			for _, replica := range n.replicas {
+				n.pool.Send(utilities.MigrateCutover{Prefix: prefix, NewPrimary: newPrimary}, replica)
+			}
+			n.storeMutex.Unlock()
+			n.endMigration(m)
+			n.pool.Send(utilities.MigrateResult{Prefix: prefix, NewPrimary: newPrimary, ID: request.ID}, request.ReplyTo)
+			return
+		}
+		n.storeMutex.Unlock()
+		if failure != "" {
+			break
+		}
+
+		if count > acked {
+			acked = count
+			lastAck = time.Now()
+		}
+		timer := time.NewTimer(time.Until(lastAck.Add(migrateTimeout)))
+		select {
+		case <-changed:
+		case <-timer.C:
+			failure = newPrimary + " acked " + strconv.Itoa(count) + " of " + strconv.Itoa(m.sent) + " writes, and nothing more for " + migrateTimeout.String()
+		case <-n.stopped:
+			failure = "the node stopped"
+		}
+		timer.Stop()
+	}
+
+	//nothing was cut over, the keys stay here and requests for them are still served. copies the new owner
+	//already applied stay there, unreachable until a later migration of the prefix overwrites them
+	n.storeMutex.Lock()
+	delete(n.migrations, prefix)
+	n.storeMutex.Unlock()
+	n.endMigration(m)
+	reason := "migration of " + prefix + " to " + newPrimary + " aborted: " + failure
+	fmt.Fprint(n.log, "warning: "+reason+"\n")
+	n.pool.Send(utilities.ErrorReply{Rejected: request.Kind(), Node: n.self, ID: request.ID, Reason: reason}, request.ReplyTo)
+}
+
+//forgets the acks and failure of a migration that is over
+func (n *Node) endMigration(m *migration) {
+	n.responseMutex.Lock()
+	delete(n.responses, m.identifier)
+	delete(n.migrationFailures, m.identifier)
+	n.responseMutex.Unlock()
+}
+
+//an answer from a migration's new owner other than a set result: a set-conflict, a redirect or an error
+//the migration it belongs to is aborted, answers to a migration that is already over are ignored
+func (n *Node) migrationFailed(identifier string, reason string) {
+	n.responseMutex.Lock()
+	if failure, running := n.migrationFailures[identifier]; running && failure == "" {
+		n.migrationFailures[identifier] = reason
+	}
+	n.responseMutex.Unlock()
+	n.responsesChanged.Notify()
+}
+
+//this will be sent from primary to replica once a migration is cut over
+func (n *Node) migrateCutover(cutover utilities.MigrateCutover) {
+	prefix := cutover.Prefix
+	n.storeMutex.Lock()
+	n.movedMutex.Lock()
+	n.moved[prefix] = cutover.NewPrimary
+	n.movedMutex.Unlock()
+	for key := range n.store {
+		if strings.HasPrefix(key, prefix) {
+			delete(n.store, key)
+			delete(n.versions, key)
+			delete(n.stamps, key)
+		}
+	}
+	n.storeMutex.Unlock()
+}
+
+//returns the moved prefix matching key and the primary now owning it, or two empty strings
+func (n *Node) movedTo(key string) (string, string) {
+	n.movedMutex.RLock()
+	defer n.movedMutex.RUnlock()
+	for prefix, newPrimary := range n.moved {
+		if strings.HasPrefix(key, prefix) {
+			return prefix, newPrimary
+		}
+	}
+	return "", ""
+}
+
+//this will be sent from an admin client to primary
+//adds a replica to the live cluster: initializes it, copies the whole store to it, waits for it to ack the copy, then tells everyone
+//the replica only joins the replica list (and starts counting towards writes' acks) once it has acked
+//storeMutex is held for the whole transition so no in-flight write counts acks against a changing replica list,
+//and no write is missing from the copy
+//output to client: ReconfigureResult, or an ErrorReply if the replica didn't ack within bootstrapTimeout
+func (n *Node) addReplica(request utilities.ReconfigureRequest) {
+	address := request.Address
+
+	n.storeMutex.Lock()
+	if n.indexOf(n.replicas, address) == -1 {
+		initialize := utilities.Initialize{
+			Role:        "replica",
+			Consistency: n.consistency,
+			Replicas:    append(append([]string{}, n.replicas...), address),
+			Self:        address,
+			Tester:      n.tester,
+			Primary:     n.primary,
+			Groups:      n.groups,
+		}
+		n.pool.Send(initialize, address)
+
+		for key, value := range n.store {
+			n.pool.Send(utilities.BootstrapSet{Key: key, Value: value, Version: n.versions[key], Stamp: n.stamps[key]}, address)
+		}
+		n.appliedMutex.RLock()
+		done := utilities.BootstrapDone{Index: n.lastIndex, Sets: len(n.store), ReplyTo: n.self, ID: "bootstrap-" + fmt.Sprint(time.Now().UnixNano())}
+		n.appliedMutex.RUnlock()
+		n.pool.Send(done, address)
+
+		timer := time.NewTimer(bootstrapTimeout)
+		acked := n.waitForAcks(done.ID, 1, timer.C)
+		timer.Stop()
+		if !acked {
+			n.storeMutex.Unlock()
+			//it thinks it is a replica of this group, but it never got to be one
+			n.pool.Send(utilities.Exit{Sender: n.self}, address)
+			reason := address + " didn't ack its copy of the store within " + bootstrapTimeout.String()
+			n.pool.Send(utilities.ErrorReply{Rejected: request.Kind(), Node: n.self, ID: request.ID, Reason: reason}, request.ReplyTo)
+			return
+		}
+
+		n.replicasMutex.Lock()
+		n.replicas = append(n.replicas, address)
+		n.replicasMutex.Unlock()
+		n.broadcastReplicas()
+	}
+	n.storeMutex.Unlock()
+
+	n.pool.Send(utilities.ReconfigureResult{Operation: "add", Address: address, ID: request.ID}, request.ReplyTo)
+}
+
+//this will be sent from an admin client to primary
+//removes a replica from the live cluster and tells it to exit
+//output to client: ReconfigureResult
+func (n *Node) removeReplica(request utilities.ReconfigureRequest) {
+	address := request.Address
+
+	n.storeMutex.Lock()
+	idx := n.indexOf(n.replicas, address)
+	if idx != -1 {
+		n.replicasMutex.Lock()
+		n.replicas = append(n.replicas[:idx:idx], n.replicas[idx+1:]...)
+		n.replicasMutex.Unlock()
+		n.broadcastReplicas()
+		n.pool.Send(utilities.Exit{Sender: n.self}, address)
+	}
+	n.storeMutex.Unlock()
+
+	n.pool.Send(utilities.ReconfigureResult{Operation: "remove", Address: address, ID: request.ID}, request.ReplyTo)
+}
+
+//this will be sent from primary to a newly added replica, once per key in the store
+func (n *Node) bootstrapSet(message utilities.BootstrapSet) {
+	n.clock.Update(message.Stamp)
+	n.storeMutex.Lock()
+	if n.stamps[message.Key].Before(message.Stamp) {
+		n.store[message.Key] = message.Value
+		n.versions[message.Key] = message.Version
+		n.stamps[message.Key] = message.Stamp
+	}
+	n.bootstrapped++
+	n.storeMutex.Unlock()
+	n.bootstrapChanged.Notify()
+}
+
+//this will be sent from primary to a newly added replica after the last bootstrap-set
+//the copy it was sent covers every write up to the primary's current index
+//bootstrap-sets are handled concurrently, so this waits until all of them have been applied before moving appliedIndex
+//output to primary: BootstrapAck, after which the primary counts this replica as one of its own
+func (n *Node) bootstrapDone(message utilities.BootstrapDone) {
+	for {
+		changed := n.bootstrapChanged.Changed()
+		n.storeMutex.Lock()
+		if n.bootstrapped >= message.Sets {
+			break
+		}
+		n.storeMutex.Unlock()
+		select {
+		case <-changed:
+		case <-n.stopped:
+			return
+		}
+	}
+	if message.Index > n.appliedIndex {
+		n.appliedIndex = message.Index
+	}
+	n.advanceAppliedIndex()
+	n.storeMutex.Unlock()
+
+	n.pool.Send(utilities.BootstrapAck{Replica: n.self, ID: message.ID}, message.ReplyTo)
+}
+
+//this will be sent from primary to replicas and clients whenever the replica set changes
+//the primary is included so clients talking to several groups know which group changed
+func (n *Node) replicasUpdate(update utilities.ReplicasUpdate) {
+	n.storeMutex.Lock()
+	n.replicasMutex.Lock()
+	n.replicas = update.Replicas
+	n.replicasMutex.Unlock()
+	n.storeMutex.Unlock()
+}
+
+//tells replicas and clients about the current replica list, caller must hold storeMutex
+func (n *Node) broadcastReplicas() {
+	update := utilities.ReplicasUpdate{Primary: n.primary, Replicas: n.replicas}
+	for _, replica := range n.replicas {
+		n.pool.Send(update, replica)
+	}
+	for _, client := range n.clients {
+		n.pool.Send(update, client)
+	}
+	//a watcher that can't be reached has closed, it would only slow every later change down
+	kept := n.watchers[:0]
+	for _, watcher := range n.watchers {
+		if n.pool.Send(update, watcher) == nil {
+			kept = append(kept, watcher)
+		}
+	}
+	n.watchers = kept
+}
+
+//this will be sent from a client library to the primary of each group when it starts
+//the client is told about every later change of the replica set, like the clients the tester named
+//output to client: ReplicasUpdate with the current replica set, which may have changed since the client was configured
+func (n *Node) watchReplicas(request utilities.WatchReplicas) {
+	n.storeMutex.Lock()
+	defer n.storeMutex.Unlock()
+	if n.indexOf(n.watchers, request.Client) == -1 {
+		n.watchers = append(n.watchers, request.Client)
+	}
+	n.pool.Send(utilities.ReplicasUpdate{Primary: n.primary, Replicas: n.replicas}, request.Client)
+}
+
+//returns index of x in list, or -1 if it is not there
+func (n *Node) indexOf(list []string, x string) int {
+	for i, y := range list {
+		if y == x {
+			return i
+		}
+	}
+	return -1
+}
+
+//initialization strategy after getting first message from tester
+/* Fields of the message:
+role can be either "primary" or "replica"
+consistency can be either "eventual", "consistent", "linearizable", or "chain"
+replicas are IP:LISTENINGPORT for various replicas
+self is IP:LISTENINGPORT for current proc
+tester is IP:LISTENINGPORT for the tester coordinating this runthrough
+primary is IP:LISTENINGPORT for the primary (can be same as self)
+clients are IP:LISTENINGPORT for various clients (only sent to the primary)
+each group is a primary and its replicas, if there are none this node's group is the only one
+*/
+func (n *Node) initialize(initialize utilities.Initialize) {
+	n.roleMutex.Lock()
+	n.role = initialize.Role
+	n.consistency = initialize.Consistency
+	n.replicasMutex.Lock()
+	n.replicas = initialize.Replicas
+	n.replicasMutex.Unlock()
+	n.self = initialize.Self
+	n.tester = initialize.Tester
+	n.primary = initialize.Primary
+	n.clients = initialize.Clients
+	n.groups = initialize.Groups
+	n.roleMutex.Unlock()
+	//the tester knows the node by its address in init.txt, which is what peers have to be told too
+	n.pool.SetSelf(n.self)
+	//the intent log is named after that address too
+	n.recoverIntents()
+	//printParse()
+}
+
+//testing function
+func (n *Node) printParse() {
+	for _, worker := range n.replicas {
+		fmt.Fprint(n.log, "Replica initialized: "+worker+"\n")
+	}
+
+	fmt.Fprint(n.log, "Primary initialized: "+n.primary+"\n")
+	fmt.Fprint(n.log, "Tester initialized: "+n.tester+"\n")
+	fmt.Fprint(n.log, "Consistency: "+n.consistency+"\n")
+}
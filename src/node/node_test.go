@@ -0,0 +1,122 @@
+package node
+
+import (
+	"DistKV/src/utilities"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+//values of key's siblings, sorted
+func siblingValues(n *Node, key string) string {
+	var values []string
+	for _, version := range n.siblings[key] {
+		values = append(values, version.value)
+	}
+	sort.Strings(values)
+	return strings.Join(values, " ")
+}
+
+//writes arriving at a worker in multiwriter mode, in order, and the siblings left once they have all been added
+func TestAddSibling(t *testing.T) {
+	type write struct {
+		value   string
+		dot     utilities.VectorClock
+		context utilities.VectorClock
+	}
+	tests := []struct {
+		name   string
+		writes []write
+		want   string
+	}{
+		{
+			name:   "first write",
+			writes: []write{{"a", utilities.VectorClock{"w1": 1}, nil}},
+			want:   "a",
+		},
+		{
+			name: "concurrent writes are kept side by side",
+			writes: []write{
+				{"a", utilities.VectorClock{"w1": 1}, nil},
+				{"b", utilities.VectorClock{"w2": 1}, nil},
+			},
+			want: "a b",
+		},
+		{
+			name: "a write that saw the other overwrites it",
+			writes: []write{
+				{"a", utilities.VectorClock{"w1": 1}, nil},
+				{"b", utilities.VectorClock{"w2": 1}, utilities.VectorClock{"w1": 1}},
+			},
+			want: "b",
+		},
+		{
+			name: "an overwritten write arriving late is dropped",
+			writes: []write{
+				{"b", utilities.VectorClock{"w2": 1}, utilities.VectorClock{"w1": 1}},
+				{"a", utilities.VectorClock{"w1": 1}, nil},
+			},
+			want: "b",
+		},
+		{
+			name: "a write resolving siblings overwrites all of them",
+			writes: []write{
+				{"a", utilities.VectorClock{"w1": 1}, nil},
+				{"b", utilities.VectorClock{"w2": 1}, nil},
+				{"c", utilities.VectorClock{"w1": 2}, utilities.VectorClock{"w1": 1, "w2": 1}},
+			},
+			want: "c",
+		},
+		{
+			name: "a write that saw only one sibling overwrites only that one",
+			writes: []write{
+				{"a", utilities.VectorClock{"w1": 1}, nil},
+				{"b", utilities.VectorClock{"w2": 1}, nil},
+				{"c", utilities.VectorClock{"w3": 1}, utilities.VectorClock{"w1": 1}},
+			},
+			want: "b c",
+		},
+		{
+			name: "the same write replicated twice is kept once",
+			writes: []write{
+				{"a", utilities.VectorClock{"w1": 1}, nil},
+				{"a", utilities.VectorClock{"w1": 1}, nil},
+			},
+			want: "a",
+		},
+		{
+			name: "a duplicate of an overwritten write doesn't come back",
+			writes: []write{
+				{"a", utilities.VectorClock{"w1": 1}, nil},
+				{"b", utilities.VectorClock{"w1": 2}, utilities.VectorClock{"w1": 1}},
+				{"a", utilities.VectorClock{"w1": 1}, nil},
+			},
+			want: "b",
+		},
+	}
+	for _, test := range tests {
+		n := New(Config{})
+		for _, w := range test.writes {
+			n.addSibling("x", sibling{value: w.value, dot: w.dot, context: w.context})
+		}
+		if got := siblingValues(n, "x"); got != test.want {
+			t.Errorf("%s: siblings are %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+//completed keeps writes of the clients that wrote most recently, however many clients came and went
+func TestCompletedForgetsOldClients(t *testing.T) {
+	n := New(Config{})
+	for i := 0; i < maxDedupeClients+10; i++ {
+		n.recordCompleted("client"+strconv.Itoa(i), 1, utilities.SetResult{})
+	}
+	if len(n.completed) != maxDedupeClients || len(n.lastCompleted) != maxDedupeClients {
+		t.Errorf("completed holds %d clients, want %d", len(n.completed), maxDedupeClients)
+	}
+	last := "client" + strconv.Itoa(maxDedupeClients+9)
+	if _, kept := n.completed[last]; !kept {
+		t.Errorf("the client that wrote last was forgotten")
+	}
+}
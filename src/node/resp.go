@@ -0,0 +1,400 @@
+package node
+
+import (
+	"DistKV/src/kvclient"
+	"DistKV/src/utilities"
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//how long one redis command may take, retries included
+const respTimeout = 60 * time.Second
+
+//most arguments a command may have, a longer array is refused before anything is read into it
+const maxCommandArgs = 1024
+
+//redis protocol (RESP) listener of a node, so redis clients and tools can use the store
+//commands are sent on to the cluster the way client.go sends them, through a kvclient.Client, whatever the node's role
+type respServer struct {
+	listener net.Listener
+
+	//protects client and expiries
+	mutex sync.Mutex
+	//connection to the cluster, made by the first command after the node has been initialized
+	client *kvclient.Client
+	//keys with a pending EXPIRE, each is deleted when its timer fires
+	//best effort: the expiry only lives here, it isn't replicated or stored with the key (see setExpiry)
+	expiries map[string]*time.Timer
+}
+
+//accepts redis clients until the listener is closed, each connection's commands are answered in order
+func (n *Node) serveRESP() {
+	for {
+		conn, err := n.resp.listener.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if err != nil {
+			continue
+		}
+		go n.respConn(conn)
+	}
+}
+
+func (n *Node) respConn(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+	for {
+		args, err := readCommand(r)
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				writeError(w, "ERR Protocol error: "+err.Error())
+				w.Flush()
+			}
+			return
+		}
+		if len(args) == 0 {
+			continue
+		}
+		quit := n.respCommand(w, args)
+		//replies to pipelined commands are sent together, once there are no more commands waiting
+		if r.Buffered() == 0 || quit {
+			if w.Flush() != nil || quit {
+				return
+			}
+		}
+	}
+}
+
+//runs one command and writes its reply, returns true if the client asked to close the connection
+//supported: PING, GET, SET (with EX or PX), DEL, MGET, MSET, EXISTS, INCR, EXPIRE, COMMAND and QUIT
+func (n *Node) respCommand(w *bufio.Writer, args []string) bool {
+	name := strings.ToUpper(args[0])
+	switch name {
+	case "PING":
+		if len(args) > 1 {
+			writeBulk(w, args[1])
+		} else {
+			writeSimple(w, "PONG")
+		}
+		return false
+	case "QUIT":
+		writeSimple(w, "OK")
+		return true
+	case "COMMAND":
+		//redis-cli asks for the command table when it starts, an empty one is fine
+		writeArray(w, 0)
+		return false
+	}
+	arity := map[string]int{"GET": 2, "SET": -3, "DEL": -2, "MGET": -2, "MSET": -3, "EXISTS": -2, "INCR": 2, "EXPIRE": 3}
+	expected, known := arity[name]
+	if !known {
+		writeError(w, "ERR unknown command '"+args[0]+"'")
+		return false
+	}
+	//a negative arity is a minimum
+	if expected > 0 && len(args) != expected || expected < 0 && len(args) < -expected || name == "MSET" && len(args)%2 == 0 {
+		writeError(w, "ERR wrong number of arguments for '"+strings.ToLower(name)+"' command")
+		return false
+	}
+	client, err := n.respClient()
+	if err != nil {
+		writeError(w, "ERR "+err.Error())
+		return false
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), respTimeout)
+	defer cancel()
+
+	switch name {
+	case "GET":
+		value, err := client.Get(ctx, args[1])
+		if errors.Is(err, kvclient.ErrNotFound) {
+			writeNil(w)
+		} else if err != nil {
+			writeClientError(w, err)
+		} else {
+			writeBulk(w, value)
+		}
+	case "SET":
+		var ttl time.Duration
+		if len(args) == 5 && (strings.ToUpper(args[3]) == "EX" || strings.ToUpper(args[3]) == "PX") {
+			amount, err := strconv.Atoi(args[4])
+			if err != nil || amount <= 0 {
+				writeError(w, "ERR invalid expire time in 'set' command")
+				return false
+			}
+			ttl = time.Duration(amount) * time.Second
+			if strings.ToUpper(args[3]) == "PX" {
+				ttl = time.Duration(amount) * time.Millisecond
+			}
+		} else if len(args) != 3 {
+			writeError(w, "ERR syntax error")
+			return false
+		}
+		if err := client.Set(ctx, args[1], args[2]); err != nil {
+			writeClientError(w, err)
+			return false
+		}
+		//like redis, a set replaces the key's time to live
+		n.setExpiry(args[1], ttl)
+		writeSimple(w, "OK")
+	case "MSET":
+		//keys are set one at a time, a failure leaves the keys before it set
+		for i := 1; i < len(args); i += 2 {
+			if err := client.Set(ctx, args[i], args[i+1]); err != nil {
+				writeClientError(w, err)
+				return false
+			}
+			n.setExpiry(args[i], 0)
+		}
+		writeSimple(w, "OK")
+	case "MGET":
+		values := make([]string, len(args)-1)
+		found := make([]bool, len(args)-1)
+		for i, key := range args[1:] {
+			value, err := client.Get(ctx, key)
+			if err != nil && !errors.Is(err, kvclient.ErrNotFound) {
+				writeClientError(w, err)
+				return false
+			}
+			values[i], found[i] = value, err == nil
+		}
+		writeArray(w, len(values))
+		for i, value := range values {
+			if found[i] {
+				writeBulk(w, value)
+			} else {
+				writeNil(w)
+			}
+		}
+	case "DEL", "EXISTS":
+		//both answer with how many of the keys had a value, DEL then deletes those
+		count := 0
+		for _, key := range args[1:] {
+			exists, err := keyExists(ctx, client, key)
+			if err == nil && exists && name == "DEL" {
+				err = client.Delete(ctx, key)
+				n.setExpiry(key, 0)
+			}
+			if err != nil {
+				writeClientError(w, err)
+				return false
+			}
+			if exists {
+				count++
+			}
+		}
+		writeInteger(w, count)
+	case "INCR":
+		value, err := client.Incr(ctx, args[1])
+		if errors.Is(err, kvclient.ErrNotInteger) {
+			writeError(w, "ERR value is not an integer or out of range")
+		} else if err != nil {
+			writeClientError(w, err)
+		} else {
+			writeInteger(w, value)
+		}
+	case "EXPIRE":
+		//best effort and local to this node, see setExpiry
+		seconds, err := strconv.Atoi(args[2])
+		if err != nil {
+			writeError(w, "ERR value is not an integer or out of range")
+			return false
+		}
+		exists, err := keyExists(ctx, client, args[1])
+		if err != nil {
+			writeClientError(w, err)
+			return false
+		}
+		if !exists {
+			writeInteger(w, 0)
+			return false
+		}
+		if seconds <= 0 {
+			//an expiry in the past deletes the key straight away
+			if err := client.Delete(ctx, args[1]); err != nil {
+				writeClientError(w, err)
+				return false
+			}
+			n.setExpiry(args[1], 0)
+		} else {
+			n.setExpiry(args[1], time.Duration(seconds)*time.Second)
+		}
+		writeInteger(w, 1)
+	}
+	return false
+}
+
+//client the redis commands are sent through, made the first time it is needed
+//every group the tester listed is known to it, so keys are routed to the group owning them
+func (n *Node) respClient() (*kvclient.Client, error) {
+	n.resp.mutex.Lock()
+	defer n.resp.mutex.Unlock()
+	if n.resp.client != nil {
+		return n.resp.client, nil
+	}
+	n.roleMutex.RLock()
+	defer n.roleMutex.RUnlock()
+	if n.role == "" {
+		return nil, errors.New("worker hasn't been initialized yet")
+	}
+	groups := n.groups
+	if len(groups) == 0 {
+		n.replicasMutex.RLock()
+		groups = []utilities.Group{{Primary: n.primary, Replicas: append([]string{}, n.replicas...)}}
+		n.replicasMutex.RUnlock()
+	}
+	config := kvclient.Config{Groups: groups, Consistency: n.consistency}
+	//workers on other hosts have to be able to answer, so the client listens on the node's interface and is known by the node's host
+	if host, _, err := net.SplitHostPort(n.listener.Addr().String()); err == nil {
+		config.Listen = net.JoinHostPort(host, "0")
+		if selfHost, _, err := net.SplitHostPort(n.self); err == nil {
+			config.Advertise = net.JoinHostPort(selfHost, "0")
+		}
+	}
+	client, err := kvclient.New(config)
+	if err != nil {
+		return nil, err
+	}
+	n.resp.client = client
+	return client, nil
+}
+
+//deletes key once ttl has passed, replacing any expiry it already had. a ttl of 0 just cancels the expiry
+//expiries are best effort and local to the node that took the command: the timer isn't replicated or stored
+//with the key, so it is lost if this node stops, only writes through this node's listener cancel it, and
+//the delete it sends is an ordinary write, so replicas may serve the key until it has reached them
+func (n *Node) setExpiry(key string, ttl time.Duration) {
+	n.resp.mutex.Lock()
+	defer n.resp.mutex.Unlock()
+	if timer, exists := n.resp.expiries[key]; exists {
+		timer.Stop()
+		delete(n.resp.expiries, key)
+	}
+	if ttl <= 0 {
+		return
+	}
+	var timer *time.Timer
+	//the timer can't fire before it is in expiries, its function waits for the mutex held here
+	timer = time.AfterFunc(ttl, func() {
+		n.resp.mutex.Lock()
+		current := n.resp.expiries[key] == timer
+		if current {
+			delete(n.resp.expiries, key)
+		}
+		client := n.resp.client
+		n.resp.mutex.Unlock()
+		if !current {
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), respTimeout)
+		defer cancel()
+		client.Delete(ctx, key)
+	})
+	n.resp.expiries[key] = timer
+}
+
+//stops listening for redis clients and drops every pending expiry
+func (n *Node) stopRESP() {
+	n.resp.listener.Close()
+	n.resp.mutex.Lock()
+	defer n.resp.mutex.Unlock()
+	for key, timer := range n.resp.expiries {
+		timer.Stop()
+		delete(n.resp.expiries, key)
+	}
+	if n.resp.client != nil {
+		n.resp.client.Close()
+	}
+}
+
+func keyExists(ctx context.Context, client *kvclient.Client, key string) (bool, error) {
+	_, err := client.Get(ctx, key)
+	if errors.Is(err, kvclient.ErrNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+//reads one command: an array of bulk strings, which is what redis clients send,
+//or an inline command (words separated by spaces on one line), which is what someone typing into telnet sends
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(line, "*") {
+		return strings.Fields(line), nil
+	}
+	count, err := strconv.Atoi(line[1:])
+	if err != nil || count < 0 || count > maxCommandArgs {
+		return nil, errors.New("invalid multibulk length")
+	}
+	//grown as arguments arrive, so a client announcing many of them and sending none holds no memory
+	var args []string
+	for i := 0; i < count; i++ {
+		line, err = readLine(r)
+		if err != nil {
+			return nil, err
+		}
+		if !strings.HasPrefix(line, "$") {
+			return nil, errors.New("expected '$', got '" + line + "'")
+		}
+		size, err := strconv.Atoi(line[1:])
+		if err != nil || size < 0 || size > utilities.MaxMessageSize {
+			return nil, errors.New("invalid bulk length")
+		}
+		b := make([]byte, size+2)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return nil, err
+		}
+		args = append(args, string(b[:size]))
+	}
+	return args, nil
+}
+
+//one line without its line ending
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+//replies with the error's message, which for kvclient errors says what was wrong with the request
+func writeClientError(w *bufio.Writer, err error) {
+	writeError(w, "ERR "+strings.ReplaceAll(err.Error(), "\n", " "))
+}
+
+func writeSimple(w *bufio.Writer, s string) {
+	w.WriteString("+" + s + "\r\n")
+}
+
+func writeError(w *bufio.Writer, s string) {
+	w.WriteString("-" + s + "\r\n")
+}
+
+func writeInteger(w *bufio.Writer, i int) {
+	w.WriteString(":" + strconv.Itoa(i) + "\r\n")
+}
+
+func writeBulk(w *bufio.Writer, s string) {
+	w.WriteString("$" + strconv.Itoa(len(s)) + "\r\n" + s + "\r\n")
+}
+
+func writeNil(w *bufio.Writer) {
+	w.WriteString("$-1\r\n")
+}
+
+func writeArray(w *bufio.Writer, count int) {
+	w.WriteString("*" + strconv.Itoa(count) + "\r\n")
+}
@@ -0,0 +1,41 @@
+package node
+
+import (
+	"bufio"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestReadCommand(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+		fails bool
+	}{
+		{name: "array", input: "*2\r\n$3\r\nGET\r\n$1\r\nx\r\n", want: "GET x"},
+		{name: "inline", input: "SET x 1\r\n", want: "SET x 1"},
+		{name: "empty array", input: "*0\r\n", want: ""},
+		{name: "most arguments allowed", input: "*1024\r\n" + strings.Repeat("$1\r\na\r\n", 1024), want: strings.TrimSpace(strings.Repeat("a ", 1024))},
+		{name: "too many arguments", input: "*1025\r\n", fails: true},
+		{name: "announces a million arguments and sends none", input: "*1048576\r\n", fails: true},
+		{name: "negative length", input: "*-1\r\n", fails: true},
+		{name: "argument that isn't a bulk string", input: "*1\r\n:1\r\n", fails: true},
+		{name: "array cut short", input: "*2\r\n$3\r\nGET\r\n", fails: true},
+	}
+	for _, test := range tests {
+		args, err := readCommand(bufio.NewReader(strings.NewReader(test.input)))
+		if test.fails {
+			if err == nil {
+				t.Errorf("%s: read %q, want an error", test.name, args)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		} else if got := strings.Join(args, " "); got != test.want {
+			t.Errorf("%s: read %s, want %s", test.name, strconv.Quote(got), strconv.Quote(test.want))
+		}
+	}
+}
@@ -2,13 +2,11 @@ package main
 
 import (
 	"DistKV/src/utilities"
+	"flag"
 	"fmt"
-	"net"
 	"os"
-	"strconv"
 	"strings"
 	"sync"
-	"time"
 )
 
 /**
@@ -18,18 +16,16 @@ Project: DistKV Store
 tester.go
 */
 
-var replicas []string
+//replica groups, the key space is split between them with consistent hashing
+//a plain init.txt with one primary is a single group
+var groups []utilities.Group
 var clients []string
-var primary string
 var tester string
 var consistency string
 var testModeEnabled int
 
-//buffer containing input message strings
-var messageBuffer []string
-
-//mutex to protect access to messageBuffer
-var bufferMutex sync.RWMutex
+//queue of incoming messages, filled by the producers and emptied by the consumer
+var messages = utilities.NewMessageQueue()
 
 //var to count number of clients exited so far
 var nExitedClients int
@@ -37,12 +33,20 @@ var nExitedClients int
 //mutex to protect modifications of nExitedClients
 var nExitedClientsMutex sync.RWMutex
 
+//notified whenever a client exits
+var clientExited utilities.Signal
+
+//optional arg: testmode=TRUE, to have clients read their instruction files and wait for them to finish
+//-listen listens on another address than the tester's address in init.txt, which clients still reach it at
 func main() {
 
 	nExitedClients = 0
 
+	listen := flag.String("listen", "", "address to listen on instead of the tester's address in init.txt (\":8999\", \"[::1]:8999\", \"unix:/tmp/tester.sock\")")
+	flag.Parse()
+
 	//parsing optional arg
-	if len(os.Args) >= 2 && utilities.TrimString(os.Args[1]) == "testmode=TRUE" {
+	if flag.NArg() >= 1 && utilities.TrimString(flag.Arg(0)) == "testmode=TRUE" {
 		testModeEnabled = 1
 	} else {
 		testModeEnabled = 0
@@ -59,21 +63,29 @@ func main() {
 	splitLines := strings.Split(s, "\n")
 	consistency = splitLines[1]
 	splitLines = splitLines[2:]
-	primary = splitLines[1]
+	group := utilities.Group{Primary: splitLines[1]}
 	tester = splitLines[3]
 	splitLines = splitLines[5:]
 
-	//adding replicas
-	for i, line := range splitLines {
-		if line == "\n" {
+	//adding replicas, every further "primary" line starts another replica group
+	for i := 0; i < len(splitLines); i++ {
+		line := splitLines[i]
+		if line == "\n" || line == "replicas" {
+			continue
+		}
+		if line == "primary" {
+			groups = append(groups, group)
+			i++
+			group = utilities.Group{Primary: splitLines[i]}
 			continue
 		}
 		if line == "clients" {
 			splitLines = splitLines[i+1:]
 			break
 		}
-		replicas = append(replicas, line)
+		group.Replicas = append(group.Replicas, line)
 	}
+	groups = append(groups, group)
 	for _, line := range splitLines {
 		if !strings.Contains(line, ":") {
 			break
@@ -84,16 +96,23 @@ func main() {
 	deliverInitializers()
 
 	if testModeEnabled == 1 {
-		listener, err := net.Listen("tcp", tester)
+		if *listen == "" {
+			*listen = tester
+		}
+		listener, err := utilities.Listen(*listen)
 		if err != nil {
 			panic(err)
 		}
 
-		go producerWrapper(listener)
+		//every message to and from this process goes over the pool's connections, which hand incoming ones to producer
+		pool := utilities.NewPool(tester, utilities.PoolSize, producer)
+		utilities.UsePool(pool)
+		go pool.Serve(listener)
 		go consumer()
 
 		condition := true
 		for condition {
+			exited := clientExited.Changed()
 			nExitedClientsMutex.RLock()
 			n := nExitedClients
 			nExitedClientsMutex.RUnlock()
@@ -102,12 +121,14 @@ func main() {
 				condition = false
 				continue
 			}
-			time.Sleep(time.Second / 2)
+			<-exited
 		}
 
-		utilities.SendMessage("exit "+tester, primary)
-		for _, replica := range replicas {
-			utilities.SendMessage("exit "+tester, replica)
+		for _, group := range groups {
+			utilities.SendMessage(utilities.Exit{Sender: tester}, group.Primary)
+			for _, replica := range group.Replicas {
+				utilities.SendMessage(utilities.Exit{Sender: tester}, replica)
+			}
 		}
 
 		fmt.Print("Process completed.\n")
@@ -116,121 +137,103 @@ func main() {
 }
 
 /* sends initializers to clients and workers
-Expected syntax of initializer message:
+Initializer messages are logged as:
 initialize __ROLE__
 __CONSISTENCY__
 REPLICA1 REPLICA2 REPLICA3 REPLICA4 ...
 __SELF__
 __TESTER__
 __PRIMARY__
+CLIENT1 CLIENT2 CLIENT3 ... (only sent to the primary)
+PRIMARY1 REPLICA1 REPLICA2 ... (one line per group)
+(built by utilities.Initialize)
 
 explanation:
 initialize is the tag, role can be either "primary" or "replica"
-consistency can be either "eventual", "consistent", or "linearizable"
+consistency can be either "eventual", "causal", "consistent", "linearizable", "chain", or "multiwriter"
 replica1 ... is IP:LISTENINGPORT for various replicas
 self is IP:LISTENINGPORT for current proc
 tester is IP:LISTENINGPORT for the tester coordinating this runthrough
 primary is IP:LISTENINGPORT for the primary (can be same as self)
+client1 ... is IP:LISTENINGPORT for various clients
+
+clients get the first group in the lines above, the test mode flag in place of the clients,
+then the group lines like workers
 */
 func deliverInitializers() {
 
-	//initializing primary
-	s := "initialize primary\n" + consistency + "\n"
-	for _, replica := range replicas {
-		s += replica + " "
-	}
-	s += "\n " + primary + "\n" + tester + "\n" + primary
-	utilities.SendMessage(s, primary)
-
-	//initializing replicas
-	for _, replica := range replicas {
-		s = "initialize replica\n" + consistency + "\n"
-		for _, replica2 := range replicas {
-			s += replica2 + " "
+	for _, group := range groups {
+		//every worker is told every group, so it can route requests it takes on behalf of a client (see the node's RESP listener)
+		//primary also needs the clients so it can tell them when replicas are added or removed
+		initialize := utilities.Initialize{
+			Role:        "primary",
+			Consistency: consistency,
+			Replicas:    group.Replicas,
+			Self:        group.Primary,
+			Tester:      tester,
+			Primary:     group.Primary,
+			Clients:     clients,
+			Groups:      groups,
+		}
+		utilities.SendMessage(initialize, group.Primary)
+
+		//replicas get an empty clients line
+		initialize.Role = "replica"
+		initialize.Clients = nil
+		for _, replica := range group.Replicas {
+			initialize.Self = replica
+			utilities.SendMessage(initialize, replica)
 		}
-		s += "\n" + replica + "\n" + tester + "\n" + primary
-		utilities.SendMessage(s, replica)
 	}
 
-	//initializing clients
 	for _, client := range clients {
-		s = "initialize client\n" + consistency + "\n"
-		for _, replica := range replicas {
-			s += replica + " "
+		initialize := utilities.Initialize{
+			Role:        "client",
+			Consistency: consistency,
+			Replicas:    groups[0].Replicas,
+			Self:        client,
+			Tester:      tester,
+			Primary:     groups[0].Primary,
+			TestMode:    testModeEnabled == 1,
+			Groups:      groups,
 		}
-		s += "\n" + client + "\n" + tester + "\n" + primary + "\n" + strconv.Itoa(testModeEnabled)
-		utilities.SendMessage(s, client)
+		utilities.SendMessage(initialize, client)
 	}
 
 }
 
 //testing function
 func printParse() {
-	for _, worker := range replicas {
-		fmt.Print("Replica initialized: " + worker + "\n")
+	for _, group := range groups {
+		fmt.Print("Primary initialized: " + group.Primary + "\n")
+		for _, worker := range group.Replicas {
+			fmt.Print("Replica initialized: " + worker + "\n")
+		}
 	}
 	for _, client := range clients {
 		fmt.Print("Client initialized: " + client + "\n")
 	}
-	fmt.Print("Primary initialized: " + primary + "\n")
 	fmt.Print("Tester initialized: " + tester + "\n")
 	fmt.Print("Consistency: " + consistency + "\n")
 }
 
-//put messages into queue (mutually exclusive queue access)
-func producer(connection net.Conn) {
-	for {
-		b := make([]byte, 512)
-		connection.Read(b)
-		s := string(b)
-		spl := strings.Split(s, " ")
-		if utilities.ZeroByteArray(b) {
-			continue
-		}
-		bufferMutex.Lock()
-		fmt.Print("message received: " + s + "\n")
-		//acknowledgements from replicas have highest priority to prevent deadlock
-		if spl[0] == "replica-set-result" || spl[0] == "get-result" || spl[0] == "primary-set-result" {
-			messageBuffer = append([]string{s}, messageBuffer...)
-		} else {
-			messageBuffer = append(messageBuffer, s)
-		}
-		bufferMutex.Unlock()
-		time.Sleep(time.Second)
-	}
-	connection.Close()
+//puts a message from one of the pooled connections into the queue
+func producer(message utilities.Message, sender string) {
+	fmt.Print("message received: " + message.String() + "\n")
+	messages.Push(message, utilities.PriorityRequest)
 }
 
-//func to initiate one producer per socket
-func producerWrapper(listener net.Listener) {
-	for {
-		connection, _ := listener.Accept()
-		go producer(connection)
-	}
-}
-
-//consume messages from queue (mutually exclusive queue access)
+//consume messages from queue, blocking while it is empty
 func consumer() {
 	for {
-		bufferMutex.Lock()
-		len := len(messageBuffer)
-		//should've used two semaphores instead of busywaiting... ¯\_(ツ)_/¯
-		if len == 0 {
-			bufferMutex.Unlock()
-			time.Sleep(time.Second / 2)
-			continue
-		}
-
-		s := utilities.TrimString(messageBuffer[0])
-		messageBuffer = messageBuffer[1:]
-		bufferMutex.Unlock()
+		message := messages.Pop()
 
-		split := strings.Split(s, " ")
-		switch split[0] {
-		case "done":
+		switch message.(type) {
+		case utilities.Done:
 			nExitedClientsMutex.Lock()
 			nExitedClients++
 			nExitedClientsMutex.Unlock()
+			clientExited.Notify()
 		}
 
 	}
@@ -0,0 +1,197 @@
+package utilities
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//convergent replicated data type: workers update their own copy and merge each other's in any order,
+//every worker that has merged the same updates ends up with the same state
+//updates return a delta, a state holding just that update, which is what gets sent to other workers
+type CRDT interface {
+	//merges other (which must be the same type) into this state
+	Merge(other CRDT)
+	//the value clients see
+	Value() string
+}
+
+//grow-only counter: each worker counts its own increments, the value is the sum
+type GCounter map[string]int
+
+func (g GCounter) Increment(worker string, n int) CRDT {
+	g[worker] += n
+	return GCounter{worker: g[worker]}
+}
+
+func (g GCounter) Merge(other CRDT) {
+	for worker, count := range other.(GCounter) {
+		if count > g[worker] {
+			g[worker] = count
+		}
+	}
+}
+
+func (g GCounter) Value() string {
+	return strconv.Itoa(g.sum())
+}
+
+func (g GCounter) sum() int {
+	total := 0
+	for _, count := range g {
+		total += count
+	}
+	return total
+}
+
+//counter that can go up and down: one grow-only counter of increments and one of decrements
+type PNCounter struct {
+	P GCounter
+	N GCounter
+}
+
+func NewPNCounter() *PNCounter {
+	return &PNCounter{P: GCounter{}, N: GCounter{}}
+}
+
+//adds n, which may be negative
+func (c *PNCounter) Increment(worker string, n int) CRDT {
+	delta := NewPNCounter()
+	if n >= 0 {
+		delta.P = c.P.Increment(worker, n).(GCounter)
+	} else {
+		delta.N = c.N.Increment(worker, -n).(GCounter)
+	}
+	return delta
+}
+
+func (c *PNCounter) Merge(other CRDT) {
+	o := other.(*PNCounter)
+	c.P.Merge(o.P)
+	c.N.Merge(o.N)
+}
+
+func (c *PNCounter) Value() string {
+	return strconv.Itoa(c.P.sum() - c.N.sum())
+}
+
+//observed-remove set: every add gets a unique tag, a remove deletes only the tags it has seen,
+//so an add concurrent with a remove of the same element wins
+type ORSet struct {
+	Adds    map[string]map[string]bool
+	Removed map[string]bool
+}
+
+func NewORSet() *ORSet {
+	return &ORSet{Adds: map[string]map[string]bool{}, Removed: map[string]bool{}}
+}
+
+//tag must be unique across every add at every worker
+func (s *ORSet) Add(element string, tag string) CRDT {
+	delta := NewORSet()
+	delta.Adds[element] = map[string]bool{tag: true}
+	s.Merge(delta)
+	return delta
+}
+
+func (s *ORSet) Remove(element string) CRDT {
+	delta := NewORSet()
+	for tag := range s.Adds[element] {
+		delta.Removed[tag] = true
+	}
+	s.Merge(delta)
+	return delta
+}
+
+func (s *ORSet) Merge(other CRDT) {
+	o := other.(*ORSet)
+	for element, tags := range o.Adds {
+		if s.Adds[element] == nil {
+			s.Adds[element] = map[string]bool{}
+		}
+		for tag := range tags {
+			s.Adds[element][tag] = true
+		}
+	}
+	for tag := range o.Removed {
+		s.Removed[tag] = true
+	}
+}
+
+//elements with a tag that hasn't been removed, sorted, as "{A,B,C}"
+func (s *ORSet) Value() string {
+	var elements []string
+	for element, tags := range s.Adds {
+		for tag := range tags {
+			if !s.Removed[tag] {
+				elements = append(elements, element)
+				break
+			}
+		}
+	}
+	sort.Strings(elements)
+	return "{" + strings.Join(elements, ",") + "}"
+}
+
+//last-writer-wins register: the write with the latest timestamp wins, ties go to the worker with the greater address
+type LWWRegister struct {
+	Contents string
+	Stamp    Timestamp
+	Worker   string
+}
+
+func (r *LWWRegister) Set(value string, stamp Timestamp, worker string) CRDT {
+	delta := &LWWRegister{Contents: value, Stamp: stamp, Worker: worker}
+	r.Merge(delta)
+	return delta
+}
+
+func (r *LWWRegister) Merge(other CRDT) {
+	o := other.(*LWWRegister)
+	if r.Stamp.Before(o.Stamp) || r.Stamp == o.Stamp && r.Worker < o.Worker {
+		*r = *o
+	}
+}
+
+func (r *LWWRegister) Value() string {
+	if r.Stamp.IsZero() {
+		return "NULL"
+	}
+	return r.Contents
+}
+
+//empty state of the named type: "gcounter", "pncounter", "orset" or "lww"
+func NewCRDT(kind string) (CRDT, error) {
+	switch kind {
+	case "gcounter":
+		return GCounter{}, nil
+	case "pncounter":
+		return NewPNCounter(), nil
+	case "orset":
+		return NewORSet(), nil
+	case "lww":
+		return &LWWRegister{}, nil
+	}
+	return nil, errors.New(kind + " is not a crdt type")
+}
+
+//name of c's type, as taken by NewCRDT
+func CRDTKind(c CRDT) string {
+	switch c.(type) {
+	case GCounter:
+		return "gcounter"
+	case *PNCounter:
+		return "pncounter"
+	case *ORSet:
+		return "orset"
+	}
+	return "lww"
+}
+
+//state as a single token that can go in a message, the json encoding never has spaces since elements and values don't
+func EncodeCRDT(c CRDT) string {
+	b, _ := json.Marshal(c)
+	return string(b)
+}
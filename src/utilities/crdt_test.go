@@ -0,0 +1,130 @@
+package utilities
+
+import "testing"
+
+//copy of c, so merging it somewhere can't change the original
+//made the way a worker gets one, as the delta of a crdt-merge message
+func cloneCRDT(t *testing.T, c CRDT) CRDT {
+	frame, err := EncodeMessage(CRDTMerge{Key: "k", Delta: c})
+	if err != nil {
+		t.Fatalf("encoding %s: %v", EncodeCRDT(c), err)
+	}
+	m, err := DecodeMessage(frame)
+	if err != nil {
+		t.Fatalf("decoding %s: %v", EncodeCRDT(c), err)
+	}
+	return m.(CRDTMerge).Delta
+}
+
+//every order of 0 ... n-1
+func permutations(n int) [][]int {
+	if n == 0 {
+		return [][]int{{}}
+	}
+	var orders [][]int
+	for _, order := range permutations(n - 1) {
+		for i := 0; i <= len(order); i++ {
+			next := append(append(append([]int{}, order[:i]...), n-1), order[i:]...)
+			orders = append(orders, next)
+		}
+	}
+	return orders
+}
+
+//deltas of updates made at different workers, as they would be sent in crdt-merge messages
+//each worker's later updates are made after its earlier ones, the workers don't see each other's
+func crdtDeltas() map[string][]CRDT {
+	g1, g2 := GCounter{}, GCounter{}
+	p1, p2 := NewPNCounter(), NewPNCounter()
+	s1, s2 := NewORSet(), NewORSet()
+	r1, r2, r3 := &LWWRegister{}, &LWWRegister{}, &LWWRegister{}
+	return map[string][]CRDT{
+		"gcounter":  {g1.Increment("w1", 2), g2.Increment("w2", 3), g1.Increment("w1", 1)},
+		"pncounter": {p1.Increment("w1", 5), p2.Increment("w2", -2), p1.Increment("w1", -1)},
+		//w2's add of a is concurrent with w1's remove, so it survives it
+		"orset": {s1.Add("a", "w1@1"), s2.Add("b", "w2@1"), s1.Remove("a"), s2.Add("a", "w2@2")},
+		//two writes with the same timestamp go to the greater worker
+		"lww": {
+			r1.Set("x", Timestamp{Wall: 1}, "w1"),
+			r2.Set("y", Timestamp{Wall: 2}, "w2"),
+			r3.Set("z", Timestamp{Wall: 2}, "w3"),
+		},
+	}
+}
+
+var crdtWant = map[string]string{"gcounter": "6", "pncounter": "2", "orset": "{a,b}", "lww": "z"}
+
+//merging the same deltas in any order gives the same value
+func TestCRDTMergeCommutes(t *testing.T) {
+	for kind, deltas := range crdtDeltas() {
+		for _, order := range permutations(len(deltas)) {
+			c, _ := NewCRDT(kind)
+			for _, i := range order {
+				c.Merge(cloneCRDT(t, deltas[i]))
+			}
+			if c.Value() != crdtWant[kind] {
+				t.Errorf("%s: merging in order %v gives %s, want %s", kind, order, c.Value(), crdtWant[kind])
+			}
+		}
+	}
+}
+
+//merging a delta or a whole state again changes nothing
+func TestCRDTMergeIdempotent(t *testing.T) {
+	for kind, deltas := range crdtDeltas() {
+		c, _ := NewCRDT(kind)
+		for _, delta := range deltas {
+			c.Merge(cloneCRDT(t, delta))
+			c.Merge(cloneCRDT(t, delta))
+		}
+		if c.Value() != crdtWant[kind] {
+			t.Errorf("%s: merging every delta twice gives %s, want %s", kind, c.Value(), crdtWant[kind])
+		}
+		before := EncodeCRDT(c)
+		c.Merge(cloneCRDT(t, c))
+		if EncodeCRDT(c) != before {
+			t.Errorf("%s: merging a state into itself changed it from %s to %s", kind, before, EncodeCRDT(c))
+		}
+	}
+}
+
+//two workers that each saw some of the deltas agree once they merge each other's states, whichever merges first
+func TestCRDTStatesConverge(t *testing.T) {
+	for kind, deltas := range crdtDeltas() {
+		a, _ := NewCRDT(kind)
+		b, _ := NewCRDT(kind)
+		for i, delta := range deltas {
+			if i%2 == 0 {
+				a.Merge(cloneCRDT(t, delta))
+			} else {
+				b.Merge(cloneCRDT(t, delta))
+			}
+		}
+		aFirst, bFirst := cloneCRDT(t, a), cloneCRDT(t, b)
+		aFirst.Merge(cloneCRDT(t, b))
+		bFirst.Merge(cloneCRDT(t, a))
+		if aFirst.Value() != crdtWant[kind] || bFirst.Value() != crdtWant[kind] {
+			t.Errorf("%s: workers ended up with %s and %s, want %s", kind, aFirst.Value(), bFirst.Value(), crdtWant[kind])
+		}
+	}
+}
+
+func TestORSetRemoveOnlySeenAdds(t *testing.T) {
+	s := NewORSet()
+	s.Add("a", "w1@1")
+	if s.Remove("b"); s.Value() != "{a}" {
+		t.Errorf("removing a missing element changed the set to %s", s.Value())
+	}
+	if s.Remove("a"); s.Value() != "{}" {
+		t.Errorf("set is %s after removing its only element", s.Value())
+	}
+	if s.Add("a", "w1@2"); s.Value() != "{a}" {
+		t.Errorf("an element added again after a remove should be back, set is %s", s.Value())
+	}
+}
+
+func TestLWWRegisterUnset(t *testing.T) {
+	if (&LWWRegister{}).Value() != "NULL" {
+		t.Errorf("a register nobody set should read NULL")
+	}
+}
@@ -0,0 +1,63 @@
+package utilities
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"sort"
+	"strconv"
+)
+
+//number of points each replica group gets on the hash ring
+//more points spreads keys more evenly between groups
+const VirtualNodes = 64
+
+//one primary and the replicas it pushes writes to
+type Group struct {
+	Primary  string
+	Replicas []string
+}
+
+//consistent hash ring mapping keys to replica groups
+//points is sorted so lookups can binary search, owners maps each point to an index into the groups list
+type HashRing struct {
+	points []uint32
+	owners map[uint32]int
+}
+
+//builds a ring with VirtualNodes points per group, points are derived from the group's primary
+//so every process given the same groups builds the same ring
+func NewHashRing(groups []Group) *HashRing {
+	ring := &HashRing{owners: map[uint32]int{}}
+	for i, group := range groups {
+		for v := 0; v < VirtualNodes; v++ {
+			point := HashString(group.Primary + "#" + strconv.Itoa(v))
+			//on the rare collision the first group keeps the point
+			if _, exists := ring.owners[point]; exists {
+				continue
+			}
+			ring.owners[point] = i
+			ring.points = append(ring.points, point)
+		}
+	}
+	sort.Slice(ring.points, func(a, b int) bool { return ring.points[a] < ring.points[b] })
+	return ring
+}
+
+//returns index of the group owning key: the first point clockwise from the key's hash
+func (ring *HashRing) Lookup(key string) int {
+	if len(ring.points) == 0 {
+		return 0
+	}
+	h := HashString(key)
+	idx := sort.Search(len(ring.points), func(i int) bool { return ring.points[i] >= h })
+	if idx == len(ring.points) {
+		idx = 0
+	}
+	return ring.owners[ring.points[idx]]
+}
+
+//first four bytes of the md5 sum, faster hashes like fnv bunch up the near-identical virtual node names
+func HashString(x string) uint32 {
+	sum := md5.Sum([]byte(x))
+	return binary.BigEndian.Uint32(sum[:4])
+}
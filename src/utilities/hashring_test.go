@@ -0,0 +1,71 @@
+package utilities
+
+import (
+	"strconv"
+	"testing"
+)
+
+func ringGroups(n int) []Group {
+	var groups []Group
+	for i := 0; i < n; i++ {
+		groups = append(groups, Group{Primary: "localhost:" + strconv.Itoa(9000+10*i)})
+	}
+	return groups
+}
+
+//every group should own a fair share of the keys, allowing for the 64 points each one gets
+func TestHashRingDistribution(t *testing.T) {
+	const keys = 30000
+	for _, n := range []int{2, 3, 5, 8} {
+		ring := NewHashRing(ringGroups(n))
+		counts := make([]int, n)
+		for k := 0; k < keys; k++ {
+			counts[ring.Lookup("key"+strconv.Itoa(k))]++
+		}
+		fair := keys / n
+		for group, count := range counts {
+			if count < fair/2 || count > fair*2 {
+				t.Errorf("%d groups: group %d owns %d keys, a fair share is %d", n, group, count, fair)
+			}
+		}
+	}
+}
+
+//adding a group only moves keys to the new group, and moves roughly 1/N of them
+func TestHashRingStableWhenGroupAdded(t *testing.T) {
+	const keys = 30000
+	for _, n := range []int{1, 2, 4, 7} {
+		before := NewHashRing(ringGroups(n))
+		after := NewHashRing(ringGroups(n + 1))
+		moved := 0
+		for k := 0; k < keys; k++ {
+			key := "key" + strconv.Itoa(k)
+			from, to := before.Lookup(key), after.Lookup(key)
+			if from == to {
+				continue
+			}
+			if to != n {
+				t.Fatalf("%d groups: %s moved from group %d to old group %d", n, key, from, to)
+			}
+			moved++
+		}
+		expected := keys / (n + 1)
+		if moved < expected/2 || moved > expected*2 {
+			t.Errorf("adding group %d moved %d keys, expected about %d", n+1, moved, expected)
+		}
+	}
+}
+
+//the ring only depends on the groups, so every process builds the same one
+func TestHashRingDeterministic(t *testing.T) {
+	a, b := NewHashRing(ringGroups(4)), NewHashRing(ringGroups(4))
+	for k := 0; k < 1000; k++ {
+		key := "key" + strconv.Itoa(k)
+		if a.Lookup(key) != b.Lookup(key) {
+			t.Fatalf("%s maps to group %d and %d", key, a.Lookup(key), b.Lookup(key))
+		}
+	}
+	if NewHashRing(nil).Lookup("x") != 0 {
+		t.Errorf("an empty ring should map every key to group 0")
+	}
+}
@@ -0,0 +1,68 @@
+package utilities
+
+import (
+	"strconv"
+	"sync"
+)
+
+//hybrid logical clock timestamp: wall is physical time in millis, logical orders events within the same milli
+//written as "__WALL__.__LOGICAL__" in messages
+type Timestamp struct {
+	Wall    int64
+	Logical int64
+}
+
+func (t Timestamp) String() string {
+	return strconv.FormatInt(t.Wall, 10) + "." + strconv.FormatInt(t.Logical, 10)
+}
+
+//true if t happened before u, the zero timestamp is before every other
+func (t Timestamp) Before(u Timestamp) bool {
+	return t.Wall < u.Wall || t.Wall == u.Wall && t.Logical < u.Logical
+}
+
+func (t Timestamp) IsZero() bool {
+	return t.Wall == 0 && t.Logical == 0
+}
+
+//hybrid logical clock, safe to share between goroutines
+//every timestamp it hands out is after every one it handed out or was updated with before,
+//and stays close to wall time, so timestamps from different processes order causally related events correctly
+type Clock struct {
+	mutex sync.Mutex
+	last  Timestamp
+}
+
+//timestamp for a local event, such as a write or sending a message
+func (c *Clock) Now() Timestamp {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	wall := GetTimeInMillis()
+	if wall > c.last.Wall {
+		c.last = Timestamp{Wall: wall}
+	} else {
+		c.last.Logical++
+	}
+	return c.last
+}
+
+//moves the clock past a timestamp received from another process, returns the timestamp of the receive
+func (c *Clock) Update(remote Timestamp) Timestamp {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	wall := GetTimeInMillis()
+	switch {
+	case wall > c.last.Wall && wall > remote.Wall:
+		c.last = Timestamp{Wall: wall}
+	case remote.Wall > c.last.Wall:
+		c.last = Timestamp{Wall: remote.Wall, Logical: remote.Logical + 1}
+	case c.last.Wall > remote.Wall:
+		c.last.Logical++
+	default:
+		if remote.Logical > c.last.Logical {
+			c.last.Logical = remote.Logical
+		}
+		c.last.Logical++
+	}
+	return c.last
+}
@@ -0,0 +1,99 @@
+package utilities
+
+import "testing"
+
+//a wall time an hour ahead, physical time stands still next to it for the length of a test
+func future() int64 {
+	return GetTimeInMillis() + 3600*1000
+}
+
+func TestClockNow(t *testing.T) {
+	ahead := future()
+	tests := []struct {
+		name string
+		last Timestamp
+		want Timestamp
+	}{
+		{"physical time stands still", Timestamp{Wall: ahead, Logical: 3}, Timestamp{Wall: ahead, Logical: 4}},
+		{"physical time stands still, first event in the milli", Timestamp{Wall: ahead}, Timestamp{Wall: ahead, Logical: 1}},
+	}
+	for _, test := range tests {
+		c := Clock{last: test.last}
+		if got := c.Now(); got != test.want {
+			t.Errorf("%s: Now returned %s, want %s", test.name, got, test.want)
+		}
+	}
+
+	//a clock behind physical time jumps to it and starts the logical counter over
+	c := Clock{last: Timestamp{Wall: 1, Logical: 5}}
+	if got := c.Now(); got.Wall <= 1 || got.Logical != 0 {
+		t.Errorf("Now behind physical time returned %s, want the current time with logical 0", got)
+	}
+	for i := 0; i < 1000; i++ {
+		previous := c.last
+		if got := c.Now(); !previous.Before(got) {
+			t.Fatalf("Now returned %s after %s", got, previous)
+		}
+	}
+}
+
+func TestClockUpdate(t *testing.T) {
+	ahead := future()
+	tests := []struct {
+		name   string
+		last   Timestamp
+		remote Timestamp
+		want   Timestamp
+	}{
+		{"remote ahead of the clock", Timestamp{Wall: ahead, Logical: 7}, Timestamp{Wall: ahead + 5, Logical: 2}, Timestamp{Wall: ahead + 5, Logical: 3}},
+		{"clock ahead of the remote", Timestamp{Wall: ahead + 5, Logical: 2}, Timestamp{Wall: ahead, Logical: 7}, Timestamp{Wall: ahead + 5, Logical: 3}},
+		{"same wall, remote counter ahead", Timestamp{Wall: ahead, Logical: 2}, Timestamp{Wall: ahead, Logical: 7}, Timestamp{Wall: ahead, Logical: 8}},
+		{"same wall, clock counter ahead", Timestamp{Wall: ahead, Logical: 7}, Timestamp{Wall: ahead, Logical: 2}, Timestamp{Wall: ahead, Logical: 8}},
+	}
+	for _, test := range tests {
+		c := Clock{last: test.last}
+		got := c.Update(test.remote)
+		if got != test.want {
+			t.Errorf("%s: Update returned %s, want %s", test.name, got, test.want)
+		}
+		if !test.last.Before(got) || !test.remote.Before(got) {
+			t.Errorf("%s: Update returned %s, not after both %s and %s", test.name, got, test.last, test.remote)
+		}
+	}
+
+	//with both behind physical time, physical time wins and the counter starts over
+	c := Clock{last: Timestamp{Wall: 1, Logical: 7}}
+	if got := c.Update(Timestamp{Wall: 2, Logical: 3}); got.Wall <= 2 || got.Logical != 0 {
+		t.Errorf("Update behind physical time returned %s, want the current time with logical 0", got)
+	}
+}
+
+//registers set with the same timestamp agree on the worker with the greater address, whichever is merged into which
+func TestLWWTieBreak(t *testing.T) {
+	stamp := Timestamp{Wall: 10, Logical: 1}
+	tests := []struct {
+		name  string
+		first string
+		then  string
+		want  string
+	}{
+		{"greater worker merged in", "w1", "w2", "w2"},
+		{"smaller worker merged in", "w2", "w1", "w2"},
+		{"same worker", "w1", "w1", "w1"},
+	}
+	for _, test := range tests {
+		r := &LWWRegister{}
+		r.Set(test.first, stamp, test.first)
+		r.Merge(&LWWRegister{Contents: test.then, Stamp: stamp, Worker: test.then})
+		if r.Value() != test.want {
+			t.Errorf("%s: register holds %s, want %s", test.name, r.Value(), test.want)
+		}
+	}
+	//a later timestamp wins whatever the workers
+	r := &LWWRegister{}
+	r.Set("old", stamp, "w9")
+	r.Merge(&LWWRegister{Contents: "new", Stamp: Timestamp{Wall: 10, Logical: 2}, Worker: "w1"})
+	if r.Value() != "new" {
+		t.Errorf("register holds %s after a later write from a smaller worker", r.Value())
+	}
+}
@@ -0,0 +1,346 @@
+package utilities
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//bound on the number of connections a process keeps open
+const PoolSize = 64
+
+//largest message a connection accepts, anything longer means the stream is corrupt
+const MaxMessageSize = 1 << 20
+
+//how long a dialer waits for the peer to acknowledge its hello
+const handshakeTimeout = 5 * time.Second
+
+//pool used by SendMessage, nil until the process calls UsePool
+var defaultPool *Pool
+
+//makes SendMessage go through pool
+func UsePool(pool *Pool) {
+	defaultPool = pool
+}
+
+//long-lived connections to peers, at most one per peer address and at most max in total
+//either side may have dialed a connection and both sides send on it, so a request and its response
+//travel over the same connection, and the identifier in each message pairs them up
+//a connection that has been used least recently is closed to make room for a new one
+//both sides of a connection check they speak the same ProtocolVersion before using it,
+//a peer that doesn't is reported to deliver as a VersionMismatch and the connection is closed
+//every frame after the handshake holds one gob-encoded Message, a frame that doesn't is reported to deliver as Malformed
+type Pool struct {
+	//address other processes reach this one at, announced to every peer it dials. protected by mutex
+	self string
+	//called with every message that arrives on any connection, and the listener address of the peer that sent it
+	//sender is "" for a message from a process that isn't pooling, and for the pool's own version-mismatch reports
+	//it runs on the connection's read loop, so it must hand the message off and not send or block: two peers each
+	//sending to the other from inside deliver would wait for each other's read loops forever
+	deliver func(message Message, sender string)
+	max     int
+
+	mutex sync.Mutex
+	conns map[string]*pooledConn
+	//closed once the dial in progress to a peer is over, so senders wait for it instead of dialing again
+	dialing map[string]chan struct{}
+	closed  bool
+}
+
+//one connection to a peer, writes are serialized so frames don't interleave
+type pooledConn struct {
+	conn       net.Conn
+	writeMutex sync.Mutex
+	lastUsed   time.Time
+	//whether this process dialed it, rather than accepting it
+	dialed bool
+}
+
+func NewPool(self string, max int, deliver func(message Message, sender string)) *Pool {
+	return &Pool{self: self, deliver: deliver, max: max, conns: map[string]*pooledConn{}, dialing: map[string]chan struct{}{}}
+}
+
+//changes the address announced to peers this process dials from then on
+//a process listening on one address (every interface, say) and reached at another calls it once it knows the other one
+func (p *Pool) SetSelf(self string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.self = self
+}
+
+//sends message to the peer listening on destination, on the pooled connection if there is one
+//if writing fails the connection is dropped and the message is sent once more on a fresh one
+func (p *Pool) Send(message Message, destination string) error {
+	frame, err := EncodeMessage(message)
+	if err != nil {
+		return err
+	}
+	pc, err := p.get(destination)
+	if err != nil {
+		return err
+	}
+	if err = pc.write(frame); err == nil {
+		return nil
+	}
+	p.drop(destination, pc)
+	pc, err = p.get(destination)
+	if err != nil {
+		return err
+	}
+	if err = pc.write(frame); err != nil {
+		p.drop(destination, pc)
+	}
+	return err
+}
+
+//accepts connections from peers until listener is closed, reading messages from each
+func (p *Pool) Serve(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if err != nil {
+			continue
+		}
+		go p.serveConn(conn)
+	}
+}
+
+//closes every pooled connection, Send fails from then on
+func (p *Pool) Close() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.closed = true
+	for peer, pc := range p.conns {
+		pc.conn.Close()
+		delete(p.conns, peer)
+	}
+}
+
+//a peer that dialed sends "hello __ADDRESS__ __VERSION__" first and is answered "hello-ack __VERSION__" with this process's version,
+//both as plain text so peers of any version can read them, after that the connection is used for messages to it too
+//a hello without a version is from a version 1 peer
+//a connection without a hello carries messages from a sender that isn't pooling, and is only read from
+func (p *Pool) serveConn(conn net.Conn) {
+	r := bufio.NewReader(conn)
+	first, err := ReadFrame(r)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	if !bytes.HasPrefix(first, []byte("hello ")) {
+		p.deliver(decode(first), "")
+		p.read(conn, r, "", nil)
+		return
+	}
+	spl := strings.Split(string(first), " ")
+	peer := spl[1]
+	version := 1
+	if len(spl) > 2 {
+		version, _ = strconv.Atoi(spl[2])
+	}
+	//the ack is sent even on a mismatch, so the dialer learns this process's version too
+	if err = WriteFrame(conn, []byte("hello-ack "+strconv.Itoa(ProtocolVersion))); err != nil || version != ProtocolVersion {
+		conn.Close()
+		if err == nil {
+			p.deliver(VersionMismatch{Peer: peer, Version: version}, "")
+		}
+		return
+	}
+	pc := &pooledConn{conn: conn, lastUsed: time.Now()}
+	p.register(peer, pc)
+	p.read(conn, r, peer, pc)
+}
+
+//delivers every message on conn until it fails, then drops it from the pool
+func (p *Pool) read(conn net.Conn, r *bufio.Reader, peer string, pc *pooledConn) {
+	for {
+		frame, err := ReadFrame(r)
+		if err != nil {
+			if pc != nil {
+				p.drop(peer, pc)
+			}
+			conn.Close()
+			return
+		}
+		p.deliver(decode(frame), peer)
+	}
+}
+
+//message in frame, or a Malformed report of why there isn't one
+func decode(frame []byte) Message {
+	message, err := DecodeMessage(frame)
+	if err != nil {
+		return Malformed{Reason: err.Error()}
+	}
+	return message
+}
+
+//pooled connection to destination, dialing one if there is none
+//only one dial to a peer is in progress at a time, other senders wait for it and use its connection
+func (p *Pool) get(destination string) (*pooledConn, error) {
+	p.mutex.Lock()
+	for {
+		if p.closed {
+			p.mutex.Unlock()
+			return nil, errors.New("pool is closed")
+		}
+		if pc, exists := p.conns[destination]; exists {
+			pc.lastUsed = time.Now()
+			p.mutex.Unlock()
+			return pc, nil
+		}
+		dialing, isDialing := p.dialing[destination]
+		if !isDialing {
+			break
+		}
+		p.mutex.Unlock()
+		<-dialing
+		p.mutex.Lock()
+	}
+	dialing := make(chan struct{})
+	p.dialing[destination] = dialing
+	self := p.self
+	p.mutex.Unlock()
+	defer func() {
+		p.mutex.Lock()
+		delete(p.dialing, destination)
+		p.mutex.Unlock()
+		close(dialing)
+	}()
+
+	conn, err := Dial(destination)
+	if err != nil {
+		return nil, err
+	}
+	r := bufio.NewReader(conn)
+	if err = p.handshake(conn, r, self, destination); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	pc := &pooledConn{conn: conn, lastUsed: time.Now(), dialed: true}
+	registered := p.register(destination, pc)
+	go p.read(conn, r, destination, pc)
+	return registered, nil
+}
+
+//says hello on a connection this process dialed, and checks the peer acknowledges it with the same protocol version
+//a version 1 peer never acknowledges, so it is detected by the timeout
+func (p *Pool) handshake(conn net.Conn, r *bufio.Reader, self string, destination string) error {
+	if err := WriteFrame(conn, []byte("hello "+self+" "+strconv.Itoa(ProtocolVersion))); err != nil {
+		return err
+	}
+	conn.SetReadDeadline(time.Now().Add(handshakeTimeout))
+	defer conn.SetReadDeadline(time.Time{})
+	ack, err := ReadFrame(r)
+	version := 1
+	if err == nil {
+		spl := strings.Split(string(ack), " ")
+		if spl[0] != "hello-ack" || len(spl) < 2 {
+			return errors.New(destination + " answered the handshake with " + strconv.Quote(string(ack)))
+		}
+		version, _ = strconv.Atoi(spl[1])
+	} else if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+		return err
+	}
+	if version != ProtocolVersion {
+		p.deliver(VersionMismatch{Peer: destination, Version: version}, "")
+		return errors.New(destination + " speaks protocol version " + strconv.Itoa(version) + ", this process speaks " + strconv.Itoa(ProtocolVersion))
+	}
+	return nil
+}
+
+//adds pc to the pool and returns the connection to use for peer
+//if there already is one, both sides must keep the same one: if both sides dialed at once it is the one dialed by
+//the side with the smaller address, and if one side dialed both it is the newer one, since a side only dials again
+//once it has dropped its connection. the other is shut down for writing, it is still read from until the peer sees
+//it end and closes it, so nothing already sent on it is lost
+func (p *Pool) register(peer string, pc *pooledConn) *pooledConn {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if existing, exists := p.conns[peer]; exists {
+		preferDialed := p.self < peer
+		if pc.dialed != existing.dialed && existing.dialed == preferDialed {
+			closeWrite(pc.conn)
+			return existing
+		}
+		closeWrite(existing.conn)
+		p.conns[peer] = pc
+		return pc
+	}
+	if len(p.conns) >= p.max {
+		oldest := ""
+		for address, c := range p.conns {
+			if oldest == "" || c.lastUsed.Before(p.conns[oldest].lastUsed) {
+				oldest = address
+			}
+		}
+		p.conns[oldest].conn.Close()
+		delete(p.conns, oldest)
+	}
+	p.conns[peer] = pc
+	return pc
+}
+
+//closes pc and removes it from the pool, if it is still the connection for peer
+//one that isn't was replaced by register, and is left for its read loop to close once the peer has closed it
+func (p *Pool) drop(peer string, pc *pooledConn) {
+	p.mutex.Lock()
+	current := p.conns[peer] == pc
+	if current {
+		delete(p.conns, peer)
+	}
+	p.mutex.Unlock()
+	if current {
+		pc.conn.Close()
+	}
+}
+
+//ends the sending side of conn, or closes it if it can't be half closed
+func closeWrite(conn net.Conn) {
+	if c, ok := conn.(interface{ CloseWrite() error }); ok {
+		c.CloseWrite()
+		return
+	}
+	conn.Close()
+}
+
+func (pc *pooledConn) write(frame []byte) error {
+	pc.writeMutex.Lock()
+	defer pc.writeMutex.Unlock()
+	return WriteFrame(pc.conn, frame)
+}
+
+//writes frame with the four byte big-endian length in front of it
+func WriteFrame(w io.Writer, frame []byte) error {
+	b := make([]byte, 4+len(frame))
+	binary.BigEndian.PutUint32(b, uint32(len(frame)))
+	copy(b[4:], frame)
+	_, err := w.Write(b)
+	return err
+}
+
+//reads a frame written by WriteFrame
+func ReadFrame(r *bufio.Reader) ([]byte, error) {
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	size := binary.BigEndian.Uint32(header)
+	if size > MaxMessageSize {
+		return nil, errors.New("message too long")
+	}
+	b := make([]byte, size)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
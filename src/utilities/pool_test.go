@@ -0,0 +1,87 @@
+package utilities
+
+import (
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+//pool listening on a free local port, delivering into the returned channel
+func testPool(t *testing.T) (*Pool, chan Message) {
+	listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	delivered := make(chan Message, 1000)
+	pool := NewPool(ListenerAddress(listener), PoolSize, func(message Message, sender string) {
+		delivered <- message
+	})
+	go pool.Serve(listener)
+	t.Cleanup(func() {
+		listener.Close()
+		pool.Close()
+	})
+	return pool, delivered
+}
+
+//two pools sending to each other at once may both dial, every message still arrives
+//and both end up using the same connection
+func TestPoolBothSidesDial(t *testing.T) {
+	a, fromB := testPool(t)
+	b, fromA := testPool(t)
+	const count = 200
+	for i := 0; i < count; i++ {
+		id := strconv.Itoa(i)
+		go a.Send(GetRequest{Key: "x", ReplyTo: a.self, ID: "a#" + id}, b.self)
+		go b.Send(GetRequest{Key: "x", ReplyTo: b.self, ID: "b#" + id}, a.self)
+	}
+	for _, delivered := range []chan Message{fromA, fromB} {
+		for i := 0; i < count; i++ {
+			select {
+			case <-delivered:
+			case <-time.After(5 * time.Second):
+				t.Fatalf("only %d of %d messages arrived", i, count)
+			}
+		}
+	}
+
+	a.mutex.Lock()
+	b.mutex.Lock()
+	defer a.mutex.Unlock()
+	defer b.mutex.Unlock()
+	if len(a.conns) != 1 || len(b.conns) != 1 {
+		t.Fatalf("pools hold %d and %d connections, want one each", len(a.conns), len(b.conns))
+	}
+	if a.conns[b.self].conn.LocalAddr().String() != b.conns[a.self].conn.RemoteAddr().String() {
+		t.Errorf("the pools kept different connections")
+	}
+}
+
+//the connection dialed by the side with the smaller address wins, whichever is registered first
+func TestPoolRegisterKeepsOneConnection(t *testing.T) {
+	tests := []struct {
+		name        string
+		self, peer  string
+		firstDialed bool
+		wantDialed  bool
+	}{
+		{"smaller side, dialed first", "a", "b", true, true},
+		{"smaller side, accepted first", "a", "b", false, true},
+		{"greater side, dialed first", "b", "a", true, false},
+		{"greater side, accepted first", "b", "a", false, false},
+	}
+	for _, test := range tests {
+		p := NewPool(test.self, PoolSize, nil)
+		first, firstPeer := net.Pipe()
+		second, secondPeer := net.Pipe()
+		p.register(test.peer, &pooledConn{conn: first, dialed: test.firstDialed})
+		kept := p.register(test.peer, &pooledConn{conn: second, dialed: !test.firstDialed})
+		if kept.dialed != test.wantDialed || p.conns[test.peer] != kept {
+			t.Errorf("%s: kept the dialed connection: %v, want %v", test.name, kept.dialed, test.wantDialed)
+		}
+		for _, c := range []net.Conn{first, firstPeer, second, secondPeer} {
+			c.Close()
+		}
+	}
+}
@@ -0,0 +1,912 @@
+package utilities
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+//typed definitions of every message processes send each other
+//a message travels gob-encoded in a frame (see Pool), so a receiver gets the struct back with every field typed
+//each one's String is the space-separated text it is logged as, the format every message had before it was typed
+//a message expecting an answer has the address to answer at in a field named ReplyTo, and the request id in one named ID
+
+//version of the protocol this process speaks, processes exchange it when they open a pooled connection (see Pool)
+//raised whenever a message changes in a way a process of an older version would misread
+//1 is every version before the handshake carried it, 2 the last one sending messages as text
+const ProtocolVersion = 3
+
+//one message of the protocol, any of the structs below
+type Message interface {
+	//what the message is for, the first word of String
+	Kind() string
+	String() string
+}
+
+//every message type, registered with gob so the concrete type is sent along with it
+func init() {
+	for _, m := range []Message{
+		GetRequest{}, GetResult{}, SetRequest{}, SetResult{}, SetConflict{}, IncrRequest{}, IncrError{}, Redirect{},
+		ReplicateRequest{}, ReplicateAck{}, ChainSet{}, Heartbeat{}, ReadIndexRequest{}, ReadIndexResult{},
+		LeaseRequest{}, LeaseGrant{}, MultiSetRequest{}, MultiSetResult{}, MultiReplicate{}, Siblings{},
+		CRDTUpdate{}, CRDTGet{}, CRDTResult{}, CRDTMerge{}, Prepare{}, PrepareResult{}, Decision{}, DecisionResult{},
+		TxnStatus{}, MigrateRequest{}, MigrateResult{}, MigrateCutover{}, ReconfigureRequest{}, ReconfigureResult{},
+		BootstrapSet{}, BootstrapDone{}, BootstrapAck{}, ReplicasUpdate{}, WatchReplicas{}, Initialize{}, Exit{}, Done{}, ErrorReply{},
+	} {
+		gob.Register(m)
+	}
+	//crdt deltas travel inside crdt-merge messages
+	gob.Register(GCounter{})
+	gob.Register(&PNCounter{})
+	gob.Register(&ORSet{})
+	gob.Register(&LWWRegister{})
+}
+
+//what a frame holds, gob only sends the name of a concrete type for a value stored in an interface
+type envelope struct {
+	Message Message
+}
+
+//message as the bytes of one frame
+func EncodeMessage(m Message) ([]byte, error) {
+	var b bytes.Buffer
+	if err := gob.NewEncoder(&b).Encode(envelope{Message: m}); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+//message in a frame written by EncodeMessage
+func DecodeMessage(b []byte) (Message, error) {
+	var e envelope
+	if err := gob.NewDecoder(bytes.NewReader(b)).Decode(&e); err != nil {
+		return nil, errors.New("undecodable message: " + err.Error())
+	}
+	if e.Message == nil {
+		return nil, errors.New("undecodable message: frame has no message in it")
+	}
+	return e.Message, nil
+}
+
+//read of a key, sent by a client to a replica or the primary
+//"get __KEY__ __REPLYTO__ __IDENTIFIER__ __DEPENDENCY__ __OPTION1__ __OPTION2__ ..."
+//dependency is the highest write index the client has seen from the key's group (its session token in eventual mode), 0 for none
+//options are optional staleness bounds, "maxstaleness=__MILLIS__" or "maxversions=__N__"
+type GetRequest struct {
+	Key        string
+	ReplyTo    string
+	ID         string
+	Dependency int
+	Options    []string `protocol:"optional"`
+}
+
+func (m GetRequest) Kind() string { return "get" }
+
+func (m GetRequest) String() string {
+	s := "get " + m.Key + " " + m.ReplyTo + " " + m.ID
+	if m.Dependency != 0 {
+		s += " " + strconv.Itoa(m.Dependency)
+	}
+	for _, option := range m.Options {
+		s += " " + option
+	}
+	return s
+}
+
+func (m GetRequest) check() error {
+	for _, option := range m.Options {
+		spl := strings.Split(option, "=")
+		if len(spl) != 2 || (spl[0] != "maxstaleness" && spl[0] != "maxversions") {
+			return malformed(m, "unknown option "+option)
+		}
+		if _, err := strconv.Atoi(spl[1]); err != nil {
+			return malformed(m, "option "+option+" is not a number")
+		}
+	}
+	return nil
+}
+
+//answer to a get, value is "NULL" if the key has none. version is the index of the write that set the value
+//"get-result __KEY__ __VALUE__ __IDENTIFIER__ __VERSION__"
+type GetResult struct {
+	Key     string
+	Value   string
+	ID      string
+	Version int
+}
+
+func (m GetResult) Kind() string { return "get-result" }
+
+func (m GetResult) String() string {
+	return "get-result " + m.Key + " " + m.Value + " " + m.ID + " " + strconv.Itoa(m.Version)
+}
+
+//write of a key, sent by a client to the key's primary
+//"primary-set __KEY__ __VALUE__ __REPLYTO__ __IDENTIFIER__ __DEPENDENCY__ __STAMP__", dependency is left out if 0 and stamp if zero
+//stamp is only set on a write passed on by a migration: the source primary's stamp of the value (see migrate)
+type SetRequest struct {
+	Key        string
+	Value      string
+	ReplyTo    string
+	ID         string
+	Dependency int
+	Stamp      Timestamp
+}
+
+func (m SetRequest) Kind() string { return "primary-set" }
+
+func (m SetRequest) String() string {
+	s := "primary-set " + m.Key + " " + m.Value + " " + m.ReplyTo + " " + m.ID
+	if m.Dependency != 0 || !m.Stamp.IsZero() {
+		s += " " + strconv.Itoa(m.Dependency)
+	}
+	if !m.Stamp.IsZero() {
+		s += " " + m.Stamp.String()
+	}
+	return s
+}
+
+//answer to a primary-set or primary-incr, index is the write's index
+//"primary-set-result __KEY__ __VALUE__ __IDENTIFIER__ __INDEX__"
+type SetResult struct {
+	Key   string
+	Value string
+	ID    string
+	Index int
+}
+
+func (m SetResult) Kind() string { return "primary-set-result" }
+
+func (m SetResult) String() string {
+	return "primary-set-result " + m.Key + " " + m.Value + " " + m.ID + " " + strconv.Itoa(m.Index)
+}
+
+//answer to a write of a key locked by a prepared transaction, the write wasn't applied
+//"primary-set-conflict __KEY__ __VALUE__ __IDENTIFIER__"
+type SetConflict struct {
+	Key   string
+	Value string
+	ID    string
+}
+
+func (m SetConflict) Kind() string { return "primary-set-conflict" }
+
+func (m SetConflict) String() string {
+	return "primary-set-conflict " + m.Key + " " + m.Value + " " + m.ID
+}
+
+//adds one to a key's value, sent by a client to the key's primary
+//"primary-incr __KEY__ __REPLYTO__ __IDENTIFIER__ __DEPENDENCY__"
+type IncrRequest struct {
+	Key        string
+	ReplyTo    string
+	ID         string
+	Dependency int
+}
+
+func (m IncrRequest) Kind() string { return "primary-incr" }
+
+func (m IncrRequest) String() string {
+	return "primary-incr " + m.Key + " " + m.ReplyTo + " " + m.ID + " " + strconv.Itoa(m.Dependency)
+}
+
+//answer to a primary-incr of a key whose value isn't an integer
+//"primary-incr-error __KEY__ __VALUE__ __IDENTIFIER__"
+type IncrError struct {
+	Key   string
+	Value string
+	ID    string
+}
+
+func (m IncrError) Kind() string { return "primary-incr-error" }
+
+func (m IncrError) String() string {
+	return "primary-incr-error " + m.Key + " " + m.Value + " " + m.ID
+}
+
+//answer to a request for a key whose prefix was migrated to another cluster
+//"redirect __KEY__ __NEWPRIMARY__ __IDENTIFIER__ __PREFIX__"
+type Redirect struct {
+	Key        string
+	NewPrimary string
+	ID         string
+	Prefix     string
+}
+
+func (m Redirect) Kind() string { return "redirect" }
+
+func (m Redirect) String() string {
+	return "redirect " + m.Key + " " + m.NewPrimary + " " + m.ID + " " + m.Prefix
+}
+
+//write pushed from the primary to a replica, stamp is the write's timestamp and also identifies its acks
+//"replica-set __KEY__ __VALUE__ __STAMP__ __INDEX__ __DEPENDENCY__"
+type ReplicateRequest struct {
+	Key        string
+	Value      string
+	Stamp      Timestamp
+	Index      int
+	Dependency int
+}
+
+func (m ReplicateRequest) Kind() string { return "replica-set" }
+
+func (m ReplicateRequest) String() string {
+	return "replica-set " + m.Key + " " + m.Value + " " + m.Stamp.String() + " " + strconv.Itoa(m.Index) + " " + strconv.Itoa(m.Dependency)
+}
+
+//a replica's acknowledgement of a replicated write, id is the identifier the primary counts acks under
+//"replica-set-result __KEY__ __VALUE__ __IDENTIFIER__"
+type ReplicateAck struct {
+	Key   string
+	Value string
+	ID    string
+}
+
+func (m ReplicateAck) Kind() string { return "replica-set-result" }
+
+func (m ReplicateAck) String() string {
+	return "replica-set-result " + m.Key + " " + m.Value + " " + m.ID
+}
+
+//write passed down the replicas in chain mode, from the primary (head) or the previous replica
+//"chain-set __KEY__ __VALUE__ __STAMP__ __INDEX__"
+type ChainSet struct {
+	Key   string
+	Value string
+	Stamp Timestamp
+	Index int
+}
+
+func (m ChainSet) Kind() string { return "chain-set" }
+
+func (m ChainSet) String() string {
+	return "chain-set " + m.Key + " " + m.Value + " " + m.Stamp.String() + " " + strconv.Itoa(m.Index)
+}
+
+//sent from the primary to its replicas every heartbeatInterval, index is the primary's latest write
+//"heartbeat __INDEX__"
+type Heartbeat struct {
+	Index int
+}
+
+func (m Heartbeat) Kind() string { return "heartbeat" }
+
+func (m Heartbeat) String() string {
+	return "heartbeat " + strconv.Itoa(m.Index)
+}
+
+//sent from a replica to the primary in linearizable mode, before the replica answers a get
+//"read-index __REPLYTO__ __IDENTIFIER__"
+type ReadIndexRequest struct {
+	ReplyTo string
+	ID      string
+}
+
+func (m ReadIndexRequest) Kind() string { return "read-index" }
+
+func (m ReadIndexRequest) String() string {
+	return "read-index " + m.ReplyTo + " " + m.ID
+}
+
+//the primary's index of the last write it applied, including writes still replicating
+//"read-index-result __PRIMARY__ __INDEX__ __IDENTIFIER__"
+type ReadIndexResult struct {
+	Primary string
+	Index   int
+	ID      string
+}
+
+func (m ReadIndexResult) Kind() string { return "read-index-result" }
+
+func (m ReadIndexResult) String() string {
+	return "read-index-result " + m.Primary + " " + strconv.Itoa(m.Index) + " " + m.ID
+}
+
+//sent from a linearizable primary to its replicas, start is the primary's clock in millis when it asked
+//"lease-request __START__ __REPLYTO__"
+type LeaseRequest struct {
+	Start   int64
+	ReplyTo string
+}
+
+func (m LeaseRequest) Kind() string { return "lease-request" }
+
+func (m LeaseRequest) String() string {
+	return "lease-request " + strconv.FormatInt(m.Start, 10) + " " + m.ReplyTo
+}
+
+//a replica's promise not to grant a lease to any other primary, counted from start
+//"lease-grant __REPLICA__ __START__"
+type LeaseGrant struct {
+	Replica string
+	Start   int64
+}
+
+func (m LeaseGrant) Kind() string { return "lease-grant" }
+
+func (m LeaseGrant) String() string {
+	return "lease-grant " + m.Replica + " " + strconv.FormatInt(m.Start, 10)
+}
+
+//write in multiwriter mode, sent by a client to any worker of the key's group
+//context is the clock of the siblings the client last saw, which the write overwrites
+//"multi-set __KEY__ __VALUE__ __REPLYTO__ __IDENTIFIER__ __CONTEXT__"
+type MultiSetRequest struct {
+	Key     string
+	Value   string
+	ReplyTo string
+	ID      string
+	Context VectorClock
+}
+
+func (m MultiSetRequest) Kind() string { return "multi-set" }
+
+func (m MultiSetRequest) String() string {
+	return "multi-set " + m.Key + " " + m.Value + " " + m.ReplyTo + " " + m.ID + " " + m.Context.String()
+}
+
+//answer to a multi-set, clock is the new version's context
+//"multi-set-result __KEY__ __VALUE__ __IDENTIFIER__ __CLOCK__"
+type MultiSetResult struct {
+	Key   string
+	Value string
+	ID    string
+	Clock VectorClock
+}
+
+func (m MultiSetResult) Kind() string { return "multi-set-result" }
+
+func (m MultiSetResult) String() string {
+	return "multi-set-result " + m.Key + " " + m.Value + " " + m.ID + " " + m.Clock.String()
+}
+
+//multiwriter write passed from the worker that took it to the rest of the group, dot is the write's own clock
+//"multi-replicate __KEY__ __VALUE__ __DOT__ __CONTEXT__"
+type MultiReplicate struct {
+	Key     string
+	Value   string
+	Dot     VectorClock
+	Context VectorClock
+}
+
+func (m MultiReplicate) Kind() string { return "multi-replicate" }
+
+func (m MultiReplicate) String() string {
+	return "multi-replicate " + m.Key + " " + m.Value + " " + m.Dot.String() + " " + m.Context.String()
+}
+
+//answer to a get in multiwriter mode, every version of the key no other overwrote and the merge of their clocks
+//"get-siblings __KEY__ __CONTEXT__ __IDENTIFIER__ __VALUE1__ __VALUE2__ ..." (no values if the key isn't set)
+type Siblings struct {
+	Key     string
+	Context VectorClock
+	ID      string
+	Values  []string `protocol:"optional"`
+}
+
+func (m Siblings) Kind() string { return "get-siblings" }
+
+func (m Siblings) String() string {
+	s := "get-siblings " + m.Key + " " + m.Context.String() + " " + m.ID
+	for _, value := range m.Values {
+		s += " " + value
+	}
+	return s
+}
+
+//update of a convergent value, sent by a client to any worker of the key's group
+//op is "ginc", "inc", "dec", "sadd", "srem" or "lwwset", argument the amount, element or value
+//"crdt-update __KEY__ __OP__ __ARGUMENT__ __REPLYTO__ __IDENTIFIER__"
+type CRDTUpdate struct {
+	Key      string
+	Op       string
+	Argument string
+	ReplyTo  string
+	ID       string
+}
+
+func (m CRDTUpdate) Kind() string { return "crdt-update" }
+
+func (m CRDTUpdate) String() string {
+	return "crdt-update " + m.Key + " " + m.Op + " " + m.Argument + " " + m.ReplyTo + " " + m.ID
+}
+
+//read of a convergent value, sent by a client to any worker of the key's group
+//"crdt-get __KEY__ __REPLYTO__ __IDENTIFIER__"
+type CRDTGet struct {
+	Key     string
+	ReplyTo string
+	ID      string
+}
+
+func (m CRDTGet) Kind() string { return "crdt-get" }
+
+func (m CRDTGet) String() string {
+	return "crdt-get " + m.Key + " " + m.ReplyTo + " " + m.ID
+}
+
+//answer to a crdt-update or crdt-get, value is NULL if the key was never updated
+//or BADREQUEST or WRONGTYPE if the update couldn't be applied
+//"crdt-result __KEY__ __VALUE__ __IDENTIFIER__"
+type CRDTResult struct {
+	Key   string
+	Value string
+	ID    string
+}
+
+func (m CRDTResult) Kind() string { return "crdt-result" }
+
+func (m CRDTResult) String() string {
+	return "crdt-result " + m.Key + " " + m.Value + " " + m.ID
+}
+
+//crdt update passed from the worker that took it to the rest of the group, delta holds just that update
+//"crdt-merge __KEY__ __TYPE__ __DELTA__" with the delta json encoded
+type CRDTMerge struct {
+	Key   string
+	Delta CRDT
+}
+
+func (m CRDTMerge) Kind() string { return "crdt-merge" }
+
+func (m CRDTMerge) String() string {
+	if m.Delta == nil {
+		return "crdt-merge " + m.Key + " - -"
+	}
+	return "crdt-merge " + m.Key + " " + CRDTKind(m.Delta) + " " + EncodeCRDT(m.Delta)
+}
+
+func (m CRDTMerge) check() error {
+	if m.Delta == nil {
+		return malformed(m, "Delta is missing")
+	}
+	return nil
+}
+
+//first phase of a two-phase commit, sent by the coordinator (a client) to the primary of every group the transaction writes to
+//id is the transaction id, reply-to the coordinator. keys and values pair up
+//"prepare __TXID__ __COORDINATOR__ __KEY1__ __VALUE1__ __KEY2__ __VALUE2__ ..."
+type Prepare struct {
+	ID      string
+	ReplyTo string
+	Keys    []string
+	Values  []string
+}
+
+func (m Prepare) Kind() string { return "prepare" }
+
+func (m Prepare) String() string {
+	s := "prepare " + m.ID + " " + m.ReplyTo
+	for i := range m.Keys {
+		if i < len(m.Values) {
+			s += " " + m.Keys[i] + " " + m.Values[i]
+		}
+	}
+	return s
+}
+
+func (m Prepare) check() error {
+	if len(m.Keys) != len(m.Values) {
+		return malformed(m, strconv.Itoa(len(m.Keys))+" keys but "+strconv.Itoa(len(m.Values))+" values")
+	}
+	return nil
+}
+
+//a participant's vote, "yes" or "no"
+//"prepare-result __PARTICIPANT__ __VOTE__ __TXID__"
+type PrepareResult struct {
+	Participant string
+	Vote        string
+	ID          string
+}
+
+func (m PrepareResult) Kind() string { return "prepare-result" }
+
+func (m PrepareResult) String() string {
+	return "prepare-result " + m.Participant + " " + m.Vote + " " + m.ID
+}
+
+//second phase of a two-phase commit, sent by the coordinator once it has decided, and again during recovery
+//decision is "commit" or "abort", and is also the message's kind
+//"commit __TXID__ __COORDINATOR__" or "abort __TXID__ __COORDINATOR__"
+type Decision struct {
+	Decision string
+	ID       string
+	ReplyTo  string
+}
+
+func (m Decision) Kind() string { return m.Decision }
+
+func (m Decision) String() string {
+	return m.Decision + " " + m.ID + " " + m.ReplyTo
+}
+
+func (m Decision) check() error {
+	if m.Decision != "commit" && m.Decision != "abort" {
+		return malformed(m, "decision is neither commit nor abort")
+	}
+	return nil
+}
+
+//a participant's acknowledgement of the decision
+//"decision-result __PARTICIPANT__ __DECISION__ __TXID__"
+type DecisionResult struct {
+	Participant string
+	Decision    string
+	ID          string
+}
+
+func (m DecisionResult) Kind() string { return "decision-result" }
+
+func (m DecisionResult) String() string {
+	return "decision-result " + m.Participant + " " + m.Decision + " " + m.ID
+}
+
+//sent by a participant whose transaction has been prepared for too long, the coordinator answers with its decision
+//"txn-status __TXID__ __PARTICIPANT__"
+type TxnStatus struct {
+	ID      string
+	ReplyTo string
+}
+
+func (m TxnStatus) Kind() string { return "txn-status" }
+
+func (m TxnStatus) String() string {
+	return "txn-status " + m.ID + " " + m.ReplyTo
+}
+
+//moves every key starting with prefix to the cluster whose primary is newPrimary, sent by an admin client to a primary
+//"migrate __PREFIX__ __NEWPRIMARY__ __REPLYTO__ __IDENTIFIER__"
+type MigrateRequest struct {
+	Prefix     string
+	NewPrimary string
+	ReplyTo    string
+	ID         string
+}
+
+func (m MigrateRequest) Kind() string { return "migrate" }
+
+func (m MigrateRequest) String() string {
+	return "migrate " + m.Prefix + " " + m.NewPrimary + " " + m.ReplyTo + " " + m.ID
+}
+
+//answer to a migrate once it is cut over
+//"migrate-result __PREFIX__ __NEWPRIMARY__ __IDENTIFIER__"
+type MigrateResult struct {
+	Prefix     string
+	NewPrimary string
+	ID         string
+}
+
+func (m MigrateResult) Kind() string { return "migrate-result" }
+
+func (m MigrateResult) String() string {
+	return "migrate-result " + m.Prefix + " " + m.NewPrimary + " " + m.ID
+}
+
+//sent from a primary to its replicas once a migration is cut over
+//"migrate-cutover __PREFIX__ __NEWPRIMARY__"
+type MigrateCutover struct {
+	Prefix     string
+	NewPrimary string
+}
+
+func (m MigrateCutover) Kind() string { return "migrate-cutover" }
+
+func (m MigrateCutover) String() string {
+	return "migrate-cutover " + m.Prefix + " " + m.NewPrimary
+}
+
+//adds a replica to or removes one from a group, sent by an admin client to the group's primary
+//operation is "add" or "remove", the message's kind is "add-replica" or "remove-replica"
+//"add-replica __ADDRESS__ __REPLYTO__ __IDENTIFIER__"
+type ReconfigureRequest struct {
+	Operation string
+	Address   string
+	ReplyTo   string
+	ID        string
+}
+
+func (m ReconfigureRequest) Kind() string { return m.Operation + "-replica" }
+
+func (m ReconfigureRequest) String() string {
+	return m.Kind() + " " + m.Address + " " + m.ReplyTo + " " + m.ID
+}
+
+func (m ReconfigureRequest) check() error {
+	if m.Operation != "add" && m.Operation != "remove" {
+		return malformed(m, "operation is neither add nor remove")
+	}
+	return nil
+}
+
+//answer to an add-replica or remove-replica
+//"reconfigure-result __OPERATION__ __ADDRESS__ __IDENTIFIER__"
+type ReconfigureResult struct {
+	Operation string
+	Address   string
+	ID        string
+}
+
+func (m ReconfigureResult) Kind() string { return "reconfigure-result" }
+
+func (m ReconfigureResult) String() string {
+	return "reconfigure-result " + m.Operation + " " + m.Address + " " + m.ID
+}
+
+//one key of the store, sent from a primary to a replica it is adding
+//"bootstrap-set __KEY__ __VALUE__ __VERSION__ __STAMP__"
+type BootstrapSet struct {
+	Key     string
+	Value   string
+	Version int
+	Stamp   Timestamp
+}
+
+func (m BootstrapSet) Kind() string { return "bootstrap-set" }
+
+func (m BootstrapSet) String() string {
+	return "bootstrap-set " + m.Key + " " + m.Value + " " + strconv.Itoa(m.Version) + " " + m.Stamp.String()
+}
+
+//sent from a primary to a replica it is adding after the last bootstrap-set
+//the copy it was sent covers every write up to index, and is sets bootstrap-sets long
+//"bootstrap-done __INDEX__ __SETS__ __REPLYTO__ __IDENTIFIER__"
+type BootstrapDone struct {
+	Index   int
+	Sets    int
+	ReplyTo string
+	ID      string
+}
+
+func (m BootstrapDone) Kind() string { return "bootstrap-done" }
+
+func (m BootstrapDone) String() string {
+	return "bootstrap-done " + strconv.Itoa(m.Index) + " " + strconv.Itoa(m.Sets) + " " + m.ReplyTo + " " + m.ID
+}
+
+//sent from a replica being added to its primary once it has applied every bootstrap-set
+//"bootstrap-ack __REPLICA__ __IDENTIFIER__"
+type BootstrapAck struct {
+	Replica string
+	ID      string
+}
+
+func (m BootstrapAck) Kind() string { return "bootstrap-ack" }
+
+func (m BootstrapAck) String() string {
+	return "bootstrap-ack " + m.Replica + " " + m.ID
+}
+
+//sent from a primary to its replicas and the clients whenever the replica set changes
+//the primary is included so clients talking to several groups know which group changed
+//"replicas-update __PRIMARY__ __REPLICA1__ __REPLICA2__ ..."
+type ReplicasUpdate struct {
+	Primary  string
+	Replicas []string `protocol:"optional"`
+}
+
+func (m ReplicasUpdate) Kind() string { return "replicas-update" }
+
+func (m ReplicasUpdate) String() string {
+	return strings.Join(append([]string{"replicas-update", m.Primary}, m.Replicas...), " ")
+}
+
+//sent from a client the tester didn't name (a kvclient) to a primary, which sends it replicas-update messages from then on
+//"watch-replicas __CLIENT__"
+type WatchReplicas struct {
+	Client string
+}
+
+func (m WatchReplicas) Kind() string { return "watch-replicas" }
+
+func (m WatchReplicas) String() string {
+	return "watch-replicas " + m.Client
+}
+
+//first message a process gets, from the tester (or from the primary, for a replica added at runtime)
+/* Logged as:
+initialize __ROLE__
+__CONSISTENCY__
+REPLICA1 REPLICA2 REPLICA3 ...
+__SELF__
+__TESTER__
+__PRIMARY__
+CLIENT1 CLIENT2 ... (workers: only filled in for the primary) or __TESTMODE__ (clients: 1 or 0)
+PRIMARY1 REPLICA1 REPLICA2 ... (one line per group)
+*/
+type Initialize struct {
+	//"primary", "replica" or "client"
+	Role        string
+	Consistency string
+	//replicas of the process's group (a client's first group)
+	Replicas []string `protocol:"optional"`
+	Self     string
+	//empty for a process started without a tester
+	Tester  string `protocol:"optional"`
+	Primary string
+	Clients []string `protocol:"optional"`
+	//whether a client reads its instruction file rather than stdin
+	TestMode bool
+	//every group of the cluster, empty if there is only the one above
+	Groups []Group
+}
+
+func (m Initialize) Kind() string { return "initialize" }
+
+func (m Initialize) String() string {
+	s := "initialize " + m.Role + "\n" + m.Consistency + "\n" + strings.Join(m.Replicas, " ") + "\n"
+	s += m.Self + "\n" + m.Tester + "\n" + m.Primary + "\n"
+	if m.Role == "client" {
+		s += map[bool]string{true: "1", false: "0"}[m.TestMode]
+	} else {
+		s += strings.Join(m.Clients, " ")
+	}
+	for _, group := range m.Groups {
+		s += "\n" + group.Primary
+		for _, replica := range group.Replicas {
+			s += " " + replica
+		}
+	}
+	return s
+}
+
+func (m Initialize) check() error {
+	switch m.Role {
+	case "primary", "replica", "client":
+	default:
+		return malformed(m, "unknown role "+m.Role)
+	}
+	for _, group := range m.Groups {
+		if group.Primary == "" {
+			return malformed(m, "a group has no primary")
+		}
+	}
+	return nil
+}
+
+//tells a worker to stop, sender is the tester or the primary passing it on
+//"exit __SENDER__"
+type Exit struct {
+	Sender string
+}
+
+func (m Exit) Kind() string { return "exit" }
+
+func (m Exit) String() string {
+	return "exit " + m.Sender
+}
+
+//sent by a client in test mode to the tester once it has run its instruction file
+//"done __CLIENT__"
+type Done struct {
+	Client string
+}
+
+func (m Done) Kind() string { return "done" }
+
+func (m Done) String() string {
+	return "done " + m.Client
+}
+
+//answer to a message a worker couldn't handle, sent back to whoever sent it
+//"error __KIND__ __NODE__ __IDENTIFIER__ __REASON__"
+//kind is the rejected message's kind, node the worker that rejected it, identifier the request id the message carried ("-" if none)
+//reason may have spaces in it
+type ErrorReply struct {
+	Rejected string
+	Node     string
+	ID       string `protocol:"optional"`
+	Reason   string `protocol:"text"`
+}
+
+func (m ErrorReply) Kind() string { return "error" }
+
+func (m ErrorReply) String() string {
+	return "error " + field(m.Rejected) + " " + field(m.Node) + " " + field(m.ID) + " " + strings.Join(strings.Fields(m.Reason), " ")
+}
+
+func (m ErrorReply) Error() string {
+	return m.Node + " rejected " + m.Rejected + " message: " + m.Reason
+}
+
+//reported by a pool to its own process when a peer speaks another protocol version, never sent
+//"version-mismatch __PEER__ __VERSION__"
+type VersionMismatch struct {
+	Peer    string
+	Version int
+}
+
+func (m VersionMismatch) Kind() string { return "version-mismatch" }
+
+func (m VersionMismatch) String() string {
+	return "version-mismatch " + m.Peer + " " + strconv.Itoa(m.Version)
+}
+
+//reported by a pool to its own process for a frame that doesn't hold a message, never sent
+//"malformed __REASON__"
+type Malformed struct {
+	Reason string `protocol:"text"`
+}
+
+func (m Malformed) Kind() string { return "malformed" }
+
+func (m Malformed) String() string {
+	return "malformed " + m.Reason
+}
+
+func (m Malformed) check() error {
+	return errors.New(m.Reason)
+}
+
+//checks a received message has every field its handler reads
+//string fields can't be empty (unless tagged `protocol:"optional"`) or have whitespace in them (unless tagged `protocol:"text"`),
+//since keys and values are logged and stored as single words. lists can't be empty unless optional, or have such elements
+//messages with more to check than that also have a check method
+func ValidateMessage(m Message) error {
+	if m == nil {
+		return errors.New("empty message")
+	}
+	v := reflect.ValueOf(m)
+	if v.Kind() == reflect.Struct {
+		for i := 0; i < v.NumField(); i++ {
+			f := v.Type().Field(i)
+			tag := f.Tag.Get("protocol")
+			switch value := v.Field(i).Interface().(type) {
+			case string:
+				if value == "" && tag != "optional" {
+					return malformed(m, f.Name+" is empty")
+				}
+				if tag != "text" && strings.ContainsAny(value, " \t\r\n") {
+					return malformed(m, f.Name+" has whitespace in it")
+				}
+			case []string:
+				if len(value) == 0 && tag != "optional" {
+					return malformed(m, f.Name+" is empty")
+				}
+				for _, x := range value {
+					if x == "" || strings.ContainsAny(x, " \t\r\n") {
+						return malformed(m, f.Name+" has an empty element or one with whitespace in it")
+					}
+				}
+			}
+		}
+	}
+	if c, checked := m.(interface{ check() error }); checked {
+		return c.check()
+	}
+	return nil
+}
+
+//address the sender of m asked for answers at and the request id m carries, "" for either if it has none
+//a response carries the id of the request it answers
+func ReplyTarget(m Message) (string, string) {
+	v := reflect.ValueOf(m)
+	if m == nil || v.Kind() != reflect.Struct {
+		return "", ""
+	}
+	replyTo, id := "", ""
+	if f := v.FieldByName("ReplyTo"); f.IsValid() && f.Kind() == reflect.String {
+		replyTo = f.String()
+	}
+	if f := v.FieldByName("ID"); f.IsValid() && f.Kind() == reflect.String {
+		id = f.String()
+	}
+	return replyTo, id
+}
+
+//x as a single word of a logged message, "-" if it is empty or has whitespace in it
+func field(x string) string {
+	if x == "" || strings.ContainsAny(x, " \t\r\n") {
+		return "-"
+	}
+	return x
+}
+
+func malformed(m Message, reason string) error {
+	return errors.New("malformed " + field(m.Kind()) + " message: " + reason)
+}
@@ -0,0 +1,65 @@
+package utilities
+
+import (
+	"reflect"
+	"testing"
+)
+
+//every field of a message survives the trip through a frame, including the typed ones
+func TestMessageRoundTrip(t *testing.T) {
+	set := NewORSet()
+	delta := set.Add("a", "localhost:9000@1.0")
+	messages := []Message{
+		GetRequest{Key: "x", ReplyTo: "localhost:9002", ID: "c#1", Dependency: 4, Options: []string{"maxversions=2"}},
+		SetResult{Key: "x", Value: "1", ID: "c#2", Index: 7},
+		ReplicateRequest{Key: "x", Value: "1", Stamp: Timestamp{Wall: 1700000000000, Logical: 3}, Index: 7, Dependency: 5},
+		MultiSetRequest{Key: "x", Value: "1", ReplyTo: "localhost:9002", ID: "c#3", Context: VectorClock{"localhost:9000": 2}},
+		Prepare{ID: "tx", ReplyTo: "localhost:9002", Keys: []string{"a", "b"}, Values: []string{"1", "2"}},
+		CRDTMerge{Key: "s", Delta: delta},
+		ReplicasUpdate{Primary: "localhost:9000"},
+		WatchReplicas{Client: "localhost:9002"},
+		ErrorReply{Rejected: "get", Node: "localhost:9000", ID: "c#4", Reason: "Key is empty"},
+	}
+	for _, m := range messages {
+		frame, err := EncodeMessage(m)
+		if err != nil {
+			t.Fatalf("encoding %s: %v", m, err)
+		}
+		decoded, err := DecodeMessage(frame)
+		if err != nil {
+			t.Fatalf("decoding %s: %v", m, err)
+		}
+		if !reflect.DeepEqual(decoded, m) {
+			t.Errorf("sent %#v, got %#v", m, decoded)
+		}
+		if err := ValidateMessage(decoded); err != nil {
+			t.Errorf("%s should be valid: %v", m, err)
+		}
+	}
+}
+
+func TestDecodeGarbage(t *testing.T) {
+	if _, err := DecodeMessage([]byte("get x localhost:9002 c#1")); err == nil {
+		t.Errorf("a text message should not decode")
+	}
+}
+
+func TestValidateMessage(t *testing.T) {
+	invalid := []Message{
+		GetRequest{Key: "", ReplyTo: "localhost:9002", ID: "c#1"},
+		GetRequest{Key: "two words", ReplyTo: "localhost:9002", ID: "c#1"},
+		GetRequest{Key: "x", ReplyTo: "localhost:9002", ID: "c#1", Options: []string{"maxsomething=1"}},
+		SetRequest{Key: "x", Value: "1", ID: "c#1"},
+		Prepare{ID: "tx", ReplyTo: "localhost:9002", Keys: []string{"a", "b"}, Values: []string{"1"}},
+		Prepare{ID: "tx", ReplyTo: "localhost:9002"},
+		CRDTMerge{Key: "s"},
+		Initialize{Role: "observer", Consistency: "eventual", Self: "localhost:9000", Primary: "localhost:9000"},
+		Malformed{Reason: "undecodable message"},
+		nil,
+	}
+	for _, m := range invalid {
+		if err := ValidateMessage(m); err == nil {
+			t.Errorf("%#v should be rejected", m)
+		}
+	}
+}
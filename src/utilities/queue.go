@@ -0,0 +1,91 @@
+package utilities
+
+import (
+	"sync"
+)
+
+//priority classes of a MessageQueue, a message is only taken once every message of a lower class has been
+const (
+	//acknowledgements and results, a handler may be blocked waiting on them while holding a lock other requests need
+	PriorityAck = iota
+	//everything else
+	PriorityRequest
+	priorityClasses
+)
+
+//queue of incoming messages, first in first out within each priority class
+//Pop blocks until there is a message instead of polling
+type MessageQueue struct {
+	mutex    sync.Mutex
+	nonEmpty *sync.Cond
+	classes  [priorityClasses][]Message
+	closed   bool
+}
+
+func NewMessageQueue() *MessageQueue {
+	q := &MessageQueue{}
+	q.nonEmpty = sync.NewCond(&q.mutex)
+	return q
+}
+
+func (q *MessageQueue) Push(message Message, priority int) {
+	q.mutex.Lock()
+	q.classes[priority] = append(q.classes[priority], message)
+	q.mutex.Unlock()
+	q.nonEmpty.Signal()
+}
+
+//takes the oldest message of the most urgent class, waiting for one if the queue is empty
+//returns nil once the queue is closed
+func (q *MessageQueue) Pop() Message {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	for {
+		if q.closed {
+			return nil
+		}
+		for priority, messages := range q.classes {
+			if len(messages) > 0 {
+				q.classes[priority] = messages[1:]
+				return messages[0]
+			}
+		}
+		q.nonEmpty.Wait()
+	}
+}
+
+//drops every queued message and wakes every goroutine waiting in Pop
+func (q *MessageQueue) Close() {
+	q.mutex.Lock()
+	q.closed = true
+	q.mutex.Unlock()
+	q.nonEmpty.Broadcast()
+}
+
+//wakes goroutines waiting for some shared state to change, so they don't have to poll it
+//a waiter takes Changed() before checking the state, then waits on it if the state isn't what it wants yet,
+//that way a Notify between the check and the wait isn't missed
+type Signal struct {
+	mutex   sync.Mutex
+	changed chan struct{}
+}
+
+//channel that is closed by the next Notify
+func (s *Signal) Changed() <-chan struct{} {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.changed == nil {
+		s.changed = make(chan struct{})
+	}
+	return s.changed
+}
+
+//wakes every goroutine waiting on a channel from Changed
+func (s *Signal) Notify() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.changed != nil {
+		close(s.changed)
+		s.changed = nil
+	}
+}
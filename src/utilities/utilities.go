@@ -1,9 +1,12 @@
 package utilities
 
 import (
-	"fmt"
+	"errors"
 	"net"
+	"os"
+	"strconv"
 	"strings"
+	"syscall"
 	"time"
 )
 
@@ -14,10 +17,66 @@ func TrimString(x string) string {
 	return x
 }
 
-func SendMessage(message string, destination string) {
-	c, _ := net.Dial("tcp", destination)
-	fmt.Fprint(c, message)
+//sends message through the process's pool, or over a fresh connection if it has none
+//returns an error instead of panicking if destination is down
+func SendMessage(message Message, destination string) error {
+	if defaultPool != nil {
+		return defaultPool.Send(message, destination)
+	}
+	frame, err := EncodeMessage(message)
+	if err != nil {
+		return err
+	}
+	c, err := Dial(destination)
+	if err != nil {
+		return err
+	}
+	err = WriteFrame(c, frame)
 	c.Close()
+	return err
+}
+
+//addresses starting with this are unix domain socket paths ("unix:/tmp/worker.sock") rather than "host:port"
+const unixPrefix = "unix:"
+
+//listens on address, which is either "host:port" or "unix:PATH"
+//the host can be a name, an IPv4 or IPv6 address (IPv6 in brackets, "[::1]:9000"), or empty for every interface (":9000")
+//a unix socket file left behind by a process that didn't close its listener is removed first. the socket is dialed
+//to find out: it is only removed if nobody accepts, and if someone does its address is in use (syscall.EADDRINUSE)
+func Listen(address string) (net.Listener, error) {
+	if strings.HasPrefix(address, unixPrefix) {
+		path := strings.TrimPrefix(address, unixPrefix)
+		if info, err := os.Stat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+			conn, err := net.Dial("unix", path)
+			if err == nil {
+				conn.Close()
+				return nil, &net.OpError{Op: "listen", Net: "unix", Addr: &net.UnixAddr{Name: path, Net: "unix"}, Err: os.NewSyscallError("bind", syscall.EADDRINUSE)}
+			}
+			//any other failure leaves the file alone, and listening fails on it
+			if errors.Is(err, syscall.ECONNREFUSED) {
+				os.Remove(path)
+			}
+		}
+		return net.Listen("unix", path)
+	}
+	return net.Listen("tcp", address)
+}
+
+//connects to a process listening on address, in any of the forms Listen takes
+func Dial(address string) (net.Conn, error) {
+	if strings.HasPrefix(address, unixPrefix) {
+		return net.Dial("unix", strings.TrimPrefix(address, unixPrefix))
+	}
+	return net.Dial("tcp", address)
+}
+
+//address listener can be reached at, in the form Listen and Dial take
+//for a listener on every interface this is the unspecified address ("[::]:9000"), which only works from the same host
+func ListenerAddress(listener net.Listener) string {
+	if listener.Addr().Network() == "unix" {
+		return unixPrefix + listener.Addr().String()
+	}
+	return listener.Addr().String()
 }
 
 func ZeroByteArray(data []byte) bool {
@@ -33,12 +92,30 @@ func GetTimeInMillis() int64 {
 	return time.Now().UnixNano() / 1000000
 }
 
+//address turned into something that can go in a file name or an id: colons, and slashes from unix socket paths, become underscores
 func RemoveColon(x string) string {
-	spl := strings.Split(x, ":")
-	return spl[0] + "_" + spl[1]
+	return strings.NewReplacer(":", "_", "/", "_").Replace(x)
 }
 
 func AddColon(x string) string {
 	spl := strings.Split(x, "_")
 	return spl[0] + ":" + spl[1]
 }
+
+//request ids are "__CLIENTID__#__SEQUENCE__", unique as long as no two clients share a client id
+func RequestID(client string, sequence int) string {
+	return client + "#" + strconv.Itoa(sequence)
+}
+
+//splits a request id into client id and sequence number, ok is false for identifiers that aren't request ids
+func ParseRequestID(x string) (client string, sequence int, ok bool) {
+	spl := strings.Split(TrimString(x), "#")
+	if len(spl) != 2 {
+		return "", 0, false
+	}
+	sequence, err := strconv.Atoi(spl[1])
+	if err != nil {
+		return "", 0, false
+	}
+	return spl[0], sequence, true
+}
@@ -0,0 +1,35 @@
+package utilities
+
+import (
+	"errors"
+	"net"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+//a socket file nobody listens on is replaced, one somebody does is left alone
+func TestListenUnixSocket(t *testing.T) {
+	address := "unix:" + filepath.Join(t.TempDir(), "worker.sock")
+	live, err := Listen(address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Listen(address); !errors.Is(err, syscall.EADDRINUSE) {
+		t.Fatalf("listening on a socket in use returned %v, want EADDRINUSE", err)
+	}
+	if conn, err := Dial(address); err != nil {
+		t.Errorf("the first listener's socket should still be reachable: %v", err)
+	} else {
+		conn.Close()
+	}
+
+	//closed without removing its file, as if its process had crashed
+	live.(*net.UnixListener).SetUnlinkOnClose(false)
+	live.Close()
+	stale, err := Listen(address)
+	if err != nil {
+		t.Fatalf("listening on a left behind socket file: %v", err)
+	}
+	stale.Close()
+}
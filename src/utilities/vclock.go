@@ -0,0 +1,51 @@
+package utilities
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//vector clock: number of writes from each worker that a version has seen, workers missing from it have count 0
+//written as "__WORKER1__=__COUNT1__,__WORKER2__=__COUNT2__,..." in messages, or "-" if empty
+type VectorClock map[string]int
+
+func (v VectorClock) String() string {
+	if len(v) == 0 {
+		return "-"
+	}
+	var workers []string
+	for worker := range v {
+		workers = append(workers, worker)
+	}
+	sort.Strings(workers)
+	var entries []string
+	for _, worker := range workers {
+		entries = append(entries, worker+"="+strconv.Itoa(v[worker]))
+	}
+	return strings.Join(entries, ",")
+}
+
+//true if v has seen everything other has (v is the same version as other or a later one)
+func (v VectorClock) Descends(other VectorClock) bool {
+	for worker, count := range other {
+		if v[worker] < count {
+			return false
+		}
+	}
+	return true
+}
+
+//new clock that has seen everything either v or other has
+func (v VectorClock) Merge(other VectorClock) VectorClock {
+	merged := VectorClock{}
+	for worker, count := range v {
+		merged[worker] = count
+	}
+	for worker, count := range other {
+		if count > merged[worker] {
+			merged[worker] = count
+		}
+	}
+	return merged
+}
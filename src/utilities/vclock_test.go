@@ -0,0 +1,60 @@
+package utilities
+
+import "testing"
+
+func TestVectorClockDescends(t *testing.T) {
+	tests := []struct {
+		name     string
+		v, other VectorClock
+		want     bool
+	}{
+		{"both empty", VectorClock{}, VectorClock{}, true},
+		{"anything descends empty", VectorClock{"a": 1}, VectorClock{}, true},
+		{"empty doesn't descend a write", VectorClock{}, VectorClock{"a": 1}, false},
+		{"equal", VectorClock{"a": 2, "b": 1}, VectorClock{"a": 2, "b": 1}, true},
+		{"later on one worker", VectorClock{"a": 3, "b": 1}, VectorClock{"a": 2, "b": 1}, true},
+		{"earlier on one worker", VectorClock{"a": 1, "b": 1}, VectorClock{"a": 2, "b": 1}, false},
+		{"concurrent", VectorClock{"a": 2}, VectorClock{"b": 1}, false},
+		{"concurrent the other way", VectorClock{"b": 1}, VectorClock{"a": 2}, false},
+		{"missing worker counts as 0", VectorClock{"a": 1}, VectorClock{"a": 1, "b": 0}, true},
+		{"nil clock", nil, VectorClock{"a": 1}, false},
+	}
+	for _, test := range tests {
+		if got := test.v.Descends(test.other); got != test.want {
+			t.Errorf("%s: %v.Descends(%v) = %v, want %v", test.name, test.v, test.other, got, test.want)
+		}
+	}
+}
+
+func TestVectorClockMerge(t *testing.T) {
+	tests := []struct {
+		name     string
+		v, other VectorClock
+		want     string
+	}{
+		{"both empty", VectorClock{}, VectorClock{}, "-"},
+		{"nil and a write", nil, VectorClock{"a": 1}, "a=1"},
+		{"disjoint workers", VectorClock{"a": 1}, VectorClock{"b": 2}, "a=1,b=2"},
+		{"highest count wins", VectorClock{"a": 3, "b": 1}, VectorClock{"a": 1, "b": 4}, "a=3,b=4"},
+		{"same clock", VectorClock{"a": 2}, VectorClock{"a": 2}, "a=2"},
+	}
+	for _, test := range tests {
+		merged := test.v.Merge(test.other)
+		if merged.String() != test.want {
+			t.Errorf("%s: %v.Merge(%v) = %v, want %s", test.name, test.v, test.other, merged, test.want)
+		}
+		if !merged.Descends(test.v) || !merged.Descends(test.other) {
+			t.Errorf("%s: merge %v doesn't descend both clocks", test.name, merged)
+		}
+		if test.other.Merge(test.v).String() != test.want {
+			t.Errorf("%s: merge isn't commutative", test.name)
+		}
+	}
+
+	//merging doesn't change either clock
+	v, other := VectorClock{"a": 1}, VectorClock{"a": 2}
+	v.Merge(other)
+	if v["a"] != 1 || other["a"] != 2 {
+		t.Errorf("merge changed its arguments: %v %v", v, other)
+	}
+}